@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"./engine"
+)
+
+// Abilities and held items play in battles through the engine (see
+// engine/abilities.go), the commands here give them to the pokemons of the
+// players.
+
+// defaultAbility is the first ability of a species, empty when it has none
+func defaultAbility(species *Pokemon) string {
+	if len(species.Abilities) == 0 {
+		return ""
+	}
+	return species.Abilities[0]
+}
+
+// setAbility gives one of a player's pokemons an ability of its species
+func setAbility(player string, id string, name string) (string, error) {
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	species := findPokemonByNameOrID(p.Name)
+	if species == nil || len(species.Abilities) == 0 {
+		return "", fmt.Errorf("%s cannot have an ability", p.Name)
+	}
+	for _, ability := range species.Abilities {
+		if engine.EffectKey(ability) == engine.EffectKey(name) {
+			p.Ability = ability
+			return ability, nil
+		}
+	}
+	return "", fmt.Errorf("%s can have %s", p.Name, strings.Join(species.Abilities, ", "))
+}
+
+// holdItem gives one of a player's pokemons an item of their bag to hold,
+// "none" puts the item it held back in the bag
+func holdItem(player string, id string, name string) (string, error) {
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	record := findPlayerRecord(player)
+	if strings.EqualFold(name, "none") {
+		if p.Item != "" {
+			addToBag(record, engine.EffectKey(p.Item), 1)
+		}
+		p.Item = ""
+		return "", nil
+	}
+	item := engine.FindItem(name)
+	if item == nil {
+		return "", fmt.Errorf("unknown item %s, see @items", name)
+	}
+	if err := takeFromBag(player, engine.EffectKey(item.Name)); err != nil {
+		return "", err
+	}
+	if p.Item != "" {
+		addToBag(record, engine.EffectKey(p.Item), 1)
+	}
+	p.Item = item.Name
+	return item.Name, nil
+}
+
+// describeHeld shows the ability and the item of a player's pokemon, for
+// lists
+func describeHeld(p PlayerPokeInfo) string {
+	var held string
+	if p.Ability != "" {
+		held += ", Ability: " + p.Ability
+	}
+	if p.Item != "" {
+		held += ", Item: " + p.Item
+	}
+	return held
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"./engine"
+)
+
+// AI trainers are server side players without an address, created for one
+// battle by "@battle ai [easy|normal|hard]" and removed when it is over.
+//
+//   - easy attacks, and now and then switches to a random pokemon
+//   - normal attacks with the pokemon that does the most damage to the
+//     opponent's pokemon
+//   - hard compares how many hits each side needs to knock the other out,
+//     switches when another pokemon wins the matchup clearly, and finishes
+//     the opponent's pokemon when it can
+type (
+	AILevel string
+
+	AITrainer struct {
+		Level AILevel
+		rng   *rand.Rand
+	}
+)
+
+const (
+	AIEasy   AILevel = "easy"
+	AINormal AILevel = "normal"
+	AIHard   AILevel = "hard"
+)
+
+const aiThinkTime = time.Second // pause before an AI trainer plays
+
+func isAI(name string) bool {
+	p, ok := players[name]
+	return ok && p.ai != nil
+}
+
+// startAIBattle creates an AI trainer with a random team from the pokedex and
+// lets the player pick their pokemons against it
+func startAIBattle(senderName string, level AILevel, conn *net.UDPConn) {
+	addr := players[senderName].Addr
+	if level != AIEasy && level != AINormal && level != AIHard {
+		sendMessage("Error: The AI level must be easy, normal or hard!", addr, conn)
+		return
+	}
+	if len(findPlayerPokemonByPlayer(senderName)) < 3 {
+		sendMessage("Error: You need at least 3 pokemons to battle!", addr, conn)
+		return
+	}
+	name := "AI_" + senderName
+	if _, exists := players[name]; exists {
+		sendMessage("Error: Your AI trainer is busy!", addr, conn)
+		return
+	}
+	players[name] = &Player{
+		Name:                  name,
+		battleRequestSends:    make(map[string]string),
+		battleRequestReceives: make(map[string]string),
+		ai:                    &AITrainer{Level: level, rng: rand.New(rand.NewSource(getNanoTime()))},
+	}
+
+	battle := newBattle(senderName, name)
+	if err := acceptBattle(battle, conn); err != nil {
+		sendMessage("Error: "+err.Error(), addr, conn)
+		abortBattle(battle, conn)
+		return
+	}
+	battle.Picks[name] = randomTeam(players[name].ai.rng, 3)
+
+	var team []string
+	for _, p := range battle.Picks[name] {
+		team = append(team, p.Name)
+	}
+	sendMessage(fmt.Sprintf("You challenged an AI trainer (%s) with %s, %s and %s!", level, team[0], team[1], team[2]), addr, conn)
+	sendMessage("@accepted_battle", addr, conn)
+	startPickTimer(battle.battleID, conn)
+}
+
+// startAITurn lets the AI trainer on turn play after aiThinkTime
+func startAITurn(battle *Battle, trainer *AITrainer, name string, conn *net.UDPConn) {
+	scheduleTimer(battle, aiThinkTime, func() {}, func() {
+		playAction(battle, trainer.chooseAction(battle.State, name), conn)
+	})
+}
+
+// chooseAction picks the next action of the AI trainer playing as player
+func (ai *AITrainer) chooseAction(state engine.BattleState, player string) engine.Action {
+	opponent := state.ActivePokemon(state.Opponent(player))
+	active := state.ActivePokemon(player)
+	var bench []engine.BattlePokemon
+	for _, p := range state.Remaining(player) {
+		if active == nil || p.ID != active.ID {
+			bench = append(bench, p)
+		}
+	}
+	attack := engine.Action{Player: player, Kind: engine.ActionAttack}
+	change := func(p engine.BattlePokemon) engine.Action {
+		return engine.Action{Player: player, Kind: engine.ActionChange, PokemonID: p.ID}
+	}
+
+	if ai.Level == AIEasy || opponent == nil {
+		if active == nil {
+			return change(bench[ai.rng.Intn(len(bench))])
+		}
+		if len(bench) > 0 && ai.rng.Intn(5) == 0 {
+			return change(bench[ai.rng.Intn(len(bench))])
+		}
+		return attack
+	}
+
+	if ai.Level == AINormal {
+		best := -1.0
+		var bestPokemon engine.BattlePokemon
+		for _, p := range bench {
+			if dmg := engine.ExpectedDamage(&p, opponent); dmg > best {
+				best, bestPokemon = dmg, p
+			}
+		}
+		if active == nil {
+			return change(bestPokemon)
+		}
+		if engine.ExpectedDamage(active, opponent) == 0 && best > 0 {
+			return change(bestPokemon)
+		}
+		return attack
+	}
+
+	// hard: a switch costs a turn, so the pokemon coming in takes a hit first
+	if active != nil && engine.ExpectedDamage(active, opponent) >= float64(opponent.Hp) {
+		return attack
+	}
+	bestScore := math.Inf(-1)
+	var bestPokemon engine.BattlePokemon
+	for _, p := range bench {
+		incoming := p
+		if active != nil {
+			incoming.Hp -= int(engine.ExpectedDamage(opponent, &p))
+		}
+		if score := matchupScore(&incoming, opponent); score > bestScore {
+			bestScore, bestPokemon = score, p
+		}
+	}
+	if active == nil {
+		return change(bestPokemon)
+	}
+	if len(bench) > 0 && bestScore > matchupScore(active, opponent)+1 {
+		return change(bestPokemon)
+	}
+	return attack
+}
+
+// matchupScore is how many more hits the opponent needs to knock p out than
+// p needs to knock the opponent out
+func matchupScore(p *engine.BattlePokemon, opponent *engine.BattlePokemon) float64 {
+	return hitsToKnockOut(opponent, p) - hitsToKnockOut(p, opponent)
+}
+
+func hitsToKnockOut(pAtk *engine.BattlePokemon, pRecive *engine.BattlePokemon) float64 {
+	if pRecive.Hp <= 0 {
+		return 0
+	}
+	dmg := engine.ExpectedDamage(pAtk, pRecive)
+	if dmg == 0 {
+		return 100 // never, but still comparable
+	}
+	return math.Ceil(float64(pRecive.Hp) / dmg)
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"./engine"
+)
+
+type BattleStatus string
+
+// Battle lifecycle: a request waits for @accept, then both players pick their
+// pokemons, then they fight until one side has no pokemon left. A battle can
+// be aborted at any point before it is finished (deny, leave, pick timeout).
+const (
+	BattleRequested BattleStatus = "requested"
+	BattlePicking   BattleStatus = "picking"
+	BattleActive    BattleStatus = "active"
+	BattleFinished  BattleStatus = "finished"
+	BattleAborted   BattleStatus = "aborted"
+)
+
+var battleTransitions = map[BattleStatus][]BattleStatus{
+	BattleRequested: {BattlePicking, BattleAborted},
+	BattlePicking:   {BattleActive, BattleAborted},
+	BattleActive:    {BattleFinished, BattleAborted},
+}
+
+type BattleRecord struct { // a finished battle in the archive, Winner and Loser are sides in team battles
+	ID        int64               `json:"ID"`
+	Players   []string            `json:"Players"`
+	Teams     map[string][]string `json:"Teams"`
+	Winner    string              `json:"Winner"`
+	Loser     string              `json:"Loser"`
+	Result    string              `json:"Result"`
+	Turns     int                 `json:"Turns"`
+	Replay    string              `json:"Replay,omitempty"`
+	StartedAt time.Time           `json:"StartedAt"`
+	EndedAt   time.Time           `json:"EndedAt"`
+}
+
+func (b *Battle) transition(to BattleStatus) error {
+	for _, next := range battleTransitions[b.Status] {
+		if next == to {
+			b.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("battle %d cannot go from %s to %s", b.battleID, b.Status, to)
+}
+
+// newBattle stores a battle request from sender to the opponents
+func newBattle(sender string, opponents ...string) *Battle {
+	id := getNanoTime()
+	seed := getNanoTime()
+	battle := &Battle{
+		battleID:   id,
+		Players:    map[string]*Player{sender: players[sender]},
+		Picks:      make(map[string][]engine.BattlePokemon),
+		Challenger: sender,
+		Format:     engine.Singles,
+		Rules:      rulesets[defaultRuleset],
+		Status:     BattleRequested,
+		Spectators: make(map[string]bool),
+		Seed:       seed,
+		engine:     engine.NewEngine(seed, time.Now),
+		Timeouts:   make(map[string]int),
+		StartedAt:  time.Now(),
+	}
+	for _, name := range opponents {
+		battle.Players[name] = players[name]
+	}
+	gameStates[id] = battle
+	return battle
+}
+
+// acceptBattle moves a battle request to the picking phase
+func acceptBattle(battle *Battle, conn *net.UDPConn) error {
+	if err := battle.transition(BattlePicking); err != nil {
+		return err
+	}
+	for _, name := range battlePlayerNames(battle) {
+		players[name].battleID = battle.battleID
+		unspectate(name, conn)
+		leaveQueue(name)
+	}
+	return nil
+}
+
+// findBattleRequest returns the pending request from sender to receiver
+func findBattleRequest(sender string, receiver string) *Battle {
+	for _, battle := range gameStates {
+		if battle.Status != BattleRequested || battle.Challenger != sender {
+			continue
+		}
+		if _, ok := battle.Players[receiver]; ok {
+			return battle
+		}
+	}
+	return nil
+}
+
+// abortBattle cancels a battle that was not finished
+func abortBattle(battle *Battle, conn *net.UDPConn) {
+	if battle == nil {
+		return
+	}
+	wasPicking := battle.Status == BattlePicking
+	wasRequested := battle.Status == BattleRequested
+	if err := battle.transition(BattleAborted); err != nil {
+		fmt.Println("Error aborting battle:", err)
+		return
+	}
+	if wasRequested {
+		challenger := players[battle.Challenger]
+		for name := range battle.Players {
+			if challenger != nil {
+				delete(challenger.battleRequestSends, name)
+			}
+			if p := players[name]; p != nil {
+				delete(p.battleRequestReceives, battle.Challenger)
+			}
+			if len(battle.Players) > 2 {
+				sendMessage("The battle request of '"+battle.Challenger+"' was cancelled.", playerAddr(name), conn)
+			}
+		}
+	}
+	if wasPicking {
+		for name := range battle.Players {
+			sendMessage("The battle was cancelled.", playerAddr(name), conn)
+		}
+		notifySpectators(battle, "The battle was cancelled.", conn)
+	}
+	cleanupBattle(battle)
+	tournamentBattleOver(battle, "", "", conn)
+}
+
+// finishBattle ends the battle between winner and loser and archives it
+func finishBattle(id int64, winner string, loser string, result string, conn *net.UDPConn) {
+	battle := gameStates[id]
+	if battle == nil {
+		return
+	}
+	if err := battle.transition(BattleFinished); err != nil {
+		fmt.Println("Error finishing battle:", err)
+		return
+	}
+	sendMessage("@win", playerAddr(winner), conn)
+	sendMessage("@lose", playerAddr(loser), conn)
+	announce(battle, fmt.Sprintf("%s won the battle against %s (%s) after %d turns!", winner, loser, result, battle.State.Turn), conn)
+
+	replayFile, err := saveReplay(battle, winner, result)
+	if err != nil {
+		fmt.Println("Error saving replay:", err)
+	}
+	err = archiveBattle(BattleRecord{
+		ID:        id,
+		Players:   []string{winner, loser},
+		Teams:     pickedTeams(battle),
+		Winner:    winner,
+		Loser:     loser,
+		Result:    result,
+		Turns:     battle.State.Turn,
+		StartedAt: battle.StartedAt,
+		EndedAt:   time.Now(),
+		Replay:    replayFile,
+	})
+	if err != nil {
+		fmt.Println("Error archiving battle:", err)
+	}
+	carryInjuries(battle)
+	spendHeldItems(battle)
+	payRewards(battle, []string{winner}, []string{loser}, result, conn)
+	recordResult(battle, winner, loser, result, conn)
+	cleanupBattle(battle)
+	tournamentBattleOver(battle, winner, loser, conn)
+}
+
+// pickedTeams gives the IDs of the pokemons every player picked
+func pickedTeams(battle *Battle) map[string][]string {
+	teams := make(map[string][]string)
+	for name, team := range battle.Picks {
+		for _, p := range team {
+			teams[name] = append(teams[name], p.ID)
+		}
+	}
+	return teams
+}
+
+// cleanupBattle forgets a battle that is over
+func cleanupBattle(battle *Battle) {
+	stopTimer(battle)
+	releaseSpectators(battle)
+	for name := range battle.Players {
+		p, ok := players[name]
+		if ok && p.battleID != battle.battleID {
+			continue // never accepted, or busy in another battle
+		}
+		if ok {
+			p.battleID = 0
+		}
+		if isAI(name) {
+			delete(players, name)
+		}
+	}
+	delete(gameStates, battle.battleID)
+}
+
+// leaveBattles forfeits or cancels every battle of a player who quits
+func leaveBattles(name string, conn *net.UDPConn) {
+	for _, battle := range gameStates {
+		if _, ok := battle.Players[name]; !ok {
+			continue
+		}
+		switch battle.Status {
+		case BattleRequested, BattlePicking:
+			abortBattle(battle, conn)
+		case BattleActive:
+			forfeitBattle(battle, name, "forfeit", conn)
+		}
+	}
+}
+
+// forfeitBattle makes a player lose a running battle. In a battle of more
+// than two players the others go on without them.
+func forfeitBattle(battle *Battle, name string, result string, conn *net.UDPConn) {
+	if len(battle.Players) <= 2 {
+		finishBattle(battle.battleID, battle.State.Opponent(name), name, result, conn)
+		return
+	}
+	if len(battle.State.Remaining(name)) > 0 { // players who are out already lost
+		playAction(battle, engine.Action{Player: name, Kind: engine.ActionForfeit}, conn)
+	}
+}
+
+// archiveBattle appends a finished battle to the battle archive
+func archiveBattle(record BattleRecord) error {
+	var records []BattleRecord
+	data, err := ioutil.ReadFile(battleArchiveData)
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	records = append(records, record)
+	data, err = json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(battleArchiveData, data, 0644)
+}
+
+// startFight starts the battle once both players picked their pokemons. On a
+// speed tie the player who accepted the battle moves first.
+func startFight(battle *Battle, conn *net.UDPConn) {
+	battle.transition(BattleActive)
+	var accepter string
+	for name := range battle.Players {
+		if name != battle.Challenger {
+			accepter = name
+		}
+	}
+	if battle.Format != engine.Singles {
+		var events []engine.Event
+		if battle.Format == engine.Doubles {
+			battle.State, events = battle.engine.StartDoubles(battle.Picks)
+		} else {
+			battle.State, events = battle.engine.StartMulti(battle.Format, battle.Picks, battle.Sides)
+		}
+		startReplay(battle)
+		for _, name := range battle.State.Players {
+			sendMessage("@pokemon_start_battle", playerAddr(name), conn)
+		}
+		renderEvents(battle, events, conn)
+		startTurnTimer(battle.battleID, conn)
+		return
+	}
+	state, events := battle.engine.Start(battle.Picks, accepter)
+	battle.State = state
+	startReplay(battle)
+
+	for _, name := range state.Players {
+		sendMessage("@pokemon_start_battle", playerAddr(name), conn)
+	}
+	first := state.CurrentTurn
+	second := state.Opponent(first)
+	sendMessage("You attack first!", playerAddr(first), conn)
+	msg := fmt.Sprintf("Active Pokemon: %s (HP: %d)", state.ActivePokemon(second).Name, state.ActivePokemon(second).Hp)
+	sendMessage(msg, playerAddr(second), conn)
+	sendMessage("Opponent will attack first!", playerAddr(second), conn)
+	msg = fmt.Sprintf("Active Pokemon: %s (HP: %d)", state.ActivePokemon(first).Name, state.ActivePokemon(first).Hp)
+	sendMessage(msg, playerAddr(first), conn)
+
+	renderEvents(battle, events, conn)
+	startTurnTimer(battle.battleID, conn)
+}
+
+// playAction lets the engine play a player's action and tells everybody what
+// happened
+func playAction(battle *Battle, action engine.Action, conn *net.UDPConn) {
+	addr := playerAddr(action.Player)
+	if battle.State.Slots != nil && len(battle.State.Remaining(action.Player)) == 0 {
+		sendMessage("You have no pokemon left, wait for the end of the battle!", addr, conn)
+		return
+	}
+	if action.Kind == engine.ActionItem {
+		if err := checkBag(battle, action); err != nil {
+			sendMessage("Error: "+err.Error(), addr, conn)
+			return
+		}
+	}
+	state, events, err := battle.engine.Apply(battle.State, action)
+	switch err {
+	case nil:
+	case engine.ErrNotYourTurn:
+		sendMessage(notYourTurnMessage(battle, action.Player), addr, conn)
+		return
+	case engine.ErrMustSwitch:
+		sendMessage("Your pokemon fainted, you must @change first!", addr, conn)
+		sendMessage(forcedSwitchMessage(battle, action.Player), addr, conn)
+		return
+	case engine.ErrNoEffect:
+		sendMessage("It won't have any effect!", addr, conn)
+		return
+	case engine.ErrInvalidPokemon:
+		sendMessage("Invalid Pokemon", addr, conn)
+		if battle.State.ForcedSwitch[action.Player] {
+			sendMessage(forcedSwitchMessage(battle, action.Player), addr, conn)
+		}
+		return
+	default:
+		if action.Kind == engine.ActionMove && !engine.IsMove(action.Move) {
+			sendMessage("Unknown move! Moves: "+engine.MoveNames(), addr, conn)
+			return
+		}
+		switch battle.Format {
+		case engine.Doubles:
+			sendMessage("Invalid command, use @attack slot 1|2|all, @move slot name [1|2|all] or @change slot pokemonID", addr, conn)
+		case engine.TeamBattle, engine.FreeForAll:
+			targets := strings.Join(battle.State.Opponents(action.Player), "|") + "|all"
+			sendMessage("Invalid command, use @attack "+targets+", @move name ["+targets+"] or @change pokemonID", addr, conn)
+		default:
+			sendMessage("Invalid command", addr, conn)
+		}
+		return
+	}
+	recordAction(battle, action.Player, action.Command())
+	battle.State = state
+	spendItems(events)
+	if len(events) == 0 { // simultaneous formats: waiting for the other actions of the turn
+		sendMessage(plannedActionMessage(battle, action), addr, conn)
+		return
+	}
+	renderEvents(battle, events, conn)
+	if state.Winner == "" {
+		startTurnTimer(battle.battleID, conn)
+	}
+}
+
+// renderEvents turns engine events into messages for the players, the
+// spectators and the replay
+func renderEvents(battle *Battle, events []engine.Event, conn *net.UDPConn) {
+	if battle.State.Slots != nil {
+		renderSimultaneousEvents(battle, events, conn)
+		return
+	}
+	state := battle.State
+	var lastKind engine.EventKind
+	for _, event := range events {
+		switch event.Kind {
+		case engine.EventStart:
+			var sides []string
+			for _, name := range state.Players {
+				p := state.ActivePokemon(name)
+				sides = append(sides, fmt.Sprintf("%s sends %s (HP: %d)", name, p.Name, p.Hp))
+			}
+			announce(battle, fmt.Sprintf("The battle begins! %s. %s moves first.", strings.Join(sides, ", "), event.Player), conn)
+		case engine.EventHit:
+			sendMessage(fmt.Sprintf("%s hits: %d damages!", event.Pokemon, event.Damage), playerAddr(event.Player), conn)
+			sendMessage(fmt.Sprintf("%s hited: %d damages!", event.TargetPokemon, event.Damage), playerAddr(event.Target), conn)
+			sendMessage(fmt.Sprintf("Active Pokemon: %s (HP: %d)", event.TargetPokemon, event.Hp), playerAddr(event.Target), conn)
+			if p := state.ActivePokemon(event.Player); p != nil {
+				sendMessage(fmt.Sprintf("Active Pokemon: %s (HP: %d)", p.Name, p.Hp), playerAddr(event.Player), conn)
+			}
+			announce(battle, fmt.Sprintf("%s's %s hits %s's %s: %d damages! (HP: %d)", event.Player, event.Pokemon,
+				event.Target, event.TargetPokemon, event.Damage, event.Hp), conn)
+			if event.Critical {
+				sendMessage("A critical hit!", playerAddr(event.Player), conn)
+				sendMessage("A critical hit!", playerAddr(event.Target), conn)
+				announce(battle, "A critical hit!", conn)
+			}
+		case engine.EventMiss, engine.EventStat, engine.EventProtect, engine.EventFail, engine.EventFlinch, engine.EventHazard, engine.EventUse:
+			for _, name := range state.Players {
+				sendMessage(moveMessage(event), playerAddr(name), conn)
+			}
+			announce(battle, moveMessage(event), conn)
+		case engine.EventFaint:
+			announce(battle, fmt.Sprintf("%s's %s fainted!", event.Player, event.Pokemon), conn)
+			sendMessage("Your pokemon died, change the order!", playerAddr(event.Player), conn)
+			sendMessage("@pokemon_died", playerAddr(event.Player), conn)
+		case engine.EventSwitch:
+			sendMessage("@changed", playerAddr(event.Player), conn)
+			announce(battle, fmt.Sprintf("%s switched to %s (HP: %d)", event.Player, event.Pokemon, event.Hp), conn)
+		case engine.EventSendOut:
+			sendMessage("@forced_changed", playerAddr(event.Player), conn)
+			sendMessage(fmt.Sprintf("Active Pokemon: %s (HP: %d)", event.Pokemon, event.Hp), playerAddr(event.Player), conn)
+			sendMessage(fmt.Sprintf("Opponent sent out %s (HP: %d)", event.Pokemon, event.Hp), playerAddr(state.Opponent(event.Player)), conn)
+			announce(battle, fmt.Sprintf("%s sent out %s (HP: %d)", event.Player, event.Pokemon, event.Hp), conn)
+		case engine.EventField, engine.EventFieldEnd, engine.EventResidual, engine.EventHeal, engine.EventAbility, engine.EventItem:
+			for _, name := range state.Players {
+				sendMessage(fieldMessage(event), playerAddr(name), conn)
+			}
+			announce(battle, fieldMessage(event), conn)
+			if event.Kind != engine.EventField || event.Pokemon == "" {
+				continue // effects of the round, abilities and items do not change what the last action was
+			}
+		case engine.EventTurn:
+			if attackedEvents[lastKind] {
+				sendMessage("@opponent_attacked", playerAddr(event.Player), conn)
+				sendMessage("@you_acttacked", playerAddr(state.Opponent(event.Player)), conn)
+			}
+			if state.ForcedSwitch[event.Player] {
+				sendMessage(forcedSwitchMessage(battle, event.Player), playerAddr(event.Player), conn)
+				sendMessage("Opponent's pokemon fainted, waiting for them to send a new one...", playerAddr(state.Opponent(event.Player)), conn)
+			}
+		case engine.EventWin:
+			finishBattle(battle.battleID, event.Player, event.Target, "knockout", conn)
+		}
+		lastKind = event.Kind
+	}
+}
+
+// renderSimultaneousEvents is renderEvents for doubles, team battles and
+// free-for-all. Pokemons are named with their slot in doubles. With more than
+// two players everybody is told about every event.
+func renderSimultaneousEvents(battle *Battle, events []engine.Event, conn *net.UDPConn) {
+	state := battle.State
+	multi := state.Format != engine.Doubles
+	tell := func(message string) {
+		if multi {
+			for _, name := range state.Players {
+				sendMessage(message, playerAddr(name), conn)
+			}
+		}
+		announce(battle, message, conn)
+	}
+	var lastKind engine.EventKind
+	for _, event := range events {
+		switch event.Kind {
+		case engine.EventStart:
+			var sides []string
+			for _, name := range state.Players {
+				sides = append(sides, name+" sends "+describeSlots(state, name))
+			}
+			title := "The doubles battle begins!"
+			switch state.Format {
+			case engine.TeamBattle:
+				title = "The team battle begins! " + strings.Join(sideNames(state), " vs ") + "."
+			case engine.FreeForAll:
+				title = "The free-for-all begins!"
+			}
+			tell(fmt.Sprintf("%s %s.", title, strings.Join(sides, ", ")))
+		case engine.EventHit:
+			if !multi {
+				sendMessage(fmt.Sprintf("%s (slot %d) hits %s (slot %d): %d damages!", event.Pokemon, event.Slot, event.TargetPokemon, event.TargetSlot, event.Damage),
+					playerAddr(event.Player), conn)
+				sendMessage(fmt.Sprintf("%s (slot %d) hited: %d damages! (HP: %d)", event.TargetPokemon, event.TargetSlot, event.Damage, event.Hp),
+					playerAddr(event.Target), conn)
+			}
+			tell(fmt.Sprintf("%s's %s hits %s's %s: %d damages! (HP: %d)", event.Player, event.Pokemon,
+				event.Target, event.TargetPokemon, event.Damage, event.Hp))
+			if event.Critical {
+				if !multi {
+					sendMessage("A critical hit!", playerAddr(event.Player), conn)
+					sendMessage("A critical hit!", playerAddr(event.Target), conn)
+				}
+				tell("A critical hit!")
+			}
+		case engine.EventMiss, engine.EventStat, engine.EventProtect, engine.EventFail, engine.EventFlinch, engine.EventHazard, engine.EventUse:
+			if !multi {
+				for _, name := range state.Players {
+					sendMessage(moveMessage(event), playerAddr(name), conn)
+				}
+			}
+			tell(moveMessage(event))
+		case engine.EventFaint:
+			tell(fmt.Sprintf("%s's %s fainted!", event.Player, event.Pokemon))
+			if !multi {
+				sendMessage(fmt.Sprintf("Your %s (slot %d) died!", event.Pokemon, event.Slot), playerAddr(event.Player), conn)
+			}
+			if state.ForcedSwitch[event.Player] {
+				sendMessage("@pokemon_died", playerAddr(event.Player), conn)
+			}
+		case engine.EventSwitch:
+			if !multi {
+				sendMessage(fmt.Sprintf("Slot %d: %s comes in (HP: %d)", event.Slot, event.Pokemon, event.Hp), playerAddr(event.Player), conn)
+				sendMessage(fmt.Sprintf("Opponent switched slot %d to %s (HP: %d)", event.Slot, event.Pokemon, event.Hp), playerAddr(state.Opponent(event.Player)), conn)
+			}
+			tell(fmt.Sprintf("%s switched to %s (HP: %d)", event.Player, event.Pokemon, event.Hp))
+		case engine.EventSendOut:
+			sendMessage("@forced_changed", playerAddr(event.Player), conn)
+			if !multi {
+				sendMessage(fmt.Sprintf("Opponent sent out %s in slot %d (HP: %d)", event.Pokemon, event.Slot, event.Hp), playerAddr(state.Opponent(event.Player)), conn)
+			}
+			tell(fmt.Sprintf("%s sent out %s (HP: %d)", event.Player, event.Pokemon, event.Hp))
+		case engine.EventOut:
+			tell(fmt.Sprintf("%s has no pokemon left and is out of the battle!", event.Player))
+		case engine.EventField, engine.EventFieldEnd, engine.EventResidual, engine.EventHeal, engine.EventAbility, engine.EventItem:
+			if !multi {
+				for _, name := range state.Players {
+					sendMessage(fieldMessage(event), playerAddr(name), conn)
+				}
+			}
+			tell(fieldMessage(event))
+		case engine.EventTurn:
+			if event.Player != "" {
+				sendMessage(forcedSwitchMessage(battle, event.Player), playerAddr(event.Player), conn)
+				for _, name := range state.Players {
+					if name != event.Player {
+						sendMessage(event.Player+"'s pokemon fainted, waiting for them to send a new one...", playerAddr(name), conn)
+					}
+				}
+				continue
+			}
+			for _, name := range state.Players {
+				if len(state.Remaining(name)) == 0 {
+					continue
+				}
+				sendMessage(turnMessage(state, name), playerAddr(name), conn)
+				sendMessage("@opponent_attacked", playerAddr(name), conn)
+			}
+		case engine.EventWin:
+			result := "knockout"
+			if lastKind == engine.EventOut {
+				result = "forfeit"
+			}
+			if multi {
+				finishMultiBattle(battle, event.Player, result, conn)
+			} else {
+				finishBattle(battle.battleID, event.Player, event.Target, result, conn)
+			}
+		}
+		lastKind = event.Kind
+	}
+}
+
+// fieldMessage describes a weather, terrain, ability or item event
+func fieldMessage(event engine.Event) string {
+	switch event.Kind {
+	case engine.EventField:
+		start := map[string]string{"rain": "it started to rain", "sun": "the sunlight turned harsh",
+			"sandstorm": "a sandstorm kicked up", "hail": "it started to hail"}[event.Effect]
+		if engine.IsTerrain(event.Effect) {
+			start = "the field is covered with " + event.Effect
+		}
+		if event.Pokemon == "" { // set by an ability
+			return fmt.Sprintf("%s%s for %d rounds!", strings.ToUpper(start[:1]), start[1:], engine.FieldRounds)
+		}
+		return fmt.Sprintf("%s's %s used a field move: %s for %d rounds!", event.Player, event.Pokemon, start, engine.FieldRounds)
+	case engine.EventFieldEnd:
+		if engine.IsTerrain(event.Effect) {
+			return "The " + event.Effect + " faded."
+		}
+		return map[string]string{"rain": "The rain stopped.", "sun": "The sunlight faded.",
+			"sandstorm": "The sandstorm subsided.", "hail": "The hail stopped."}[event.Effect]
+	case engine.EventResidual:
+		return fmt.Sprintf("%s's %s is hurt by the %s: %d damages! (HP: %d)", event.Player, event.Pokemon, event.Effect, event.Damage, event.Hp)
+	case engine.EventHeal:
+		return fmt.Sprintf("%s's %s is healed by the %s: +%d HP (HP: %d)", event.Player, event.Pokemon, event.Effect, event.Damage, event.Hp)
+	case engine.EventAbility, engine.EventItem:
+		who := event.Player + "'s " + event.Pokemon
+		switch engine.EffectKey(event.Effect) {
+		case "intimidate":
+			return fmt.Sprintf("%s's Intimidate lowers the attack of %s's %s!", who, event.Target, event.TargetPokemon)
+		case "speedboost":
+			return who + "'s Speed Boost raises its speed!"
+		case "levitate":
+			return who + " floats with Levitate, the ground attack does nothing!"
+		case "sturdy", "focussash":
+			return fmt.Sprintf("%s endured the hit with its %s! (HP: %d)", who, event.Effect, event.Hp)
+		}
+		return who + "'s " + event.Effect + "!"
+	}
+	return ""
+}
+
+// attackedEvents are the actions after which the singles clients are told
+// who acted
+var attackedEvents = map[engine.EventKind]bool{engine.EventHit: true, engine.EventFaint: true, engine.EventField: true, engine.EventMiss: true,
+	engine.EventStat: true, engine.EventProtect: true, engine.EventFail: true, engine.EventFlinch: true, engine.EventHazard: true, engine.EventUse: true}
+
+// moveMessage describes a missed attack or the effect of a move
+func moveMessage(event engine.Event) string {
+	switch event.Kind {
+	case engine.EventMiss:
+		return fmt.Sprintf("%s's %s missed %s's %s!", event.Player, event.Pokemon, event.Target, event.TargetPokemon)
+	case engine.EventProtect:
+		if event.Target != "" {
+			return fmt.Sprintf("%s's %s protected itself from %s's %s!", event.Player, event.Pokemon, event.Target, event.TargetPokemon)
+		}
+		return fmt.Sprintf("%s's %s used %s: it protects itself!", event.Player, event.Pokemon, event.Move)
+	case engine.EventFail:
+		return fmt.Sprintf("%s's %s used %s: but it failed!", event.Player, event.Pokemon, event.Move)
+	case engine.EventFlinch:
+		return fmt.Sprintf("%s's %s flinched and couldn't move!", event.Player, event.Pokemon)
+	case engine.EventHazard:
+		return fmt.Sprintf("%s's %s used %s: %s is laid around %s's side!", event.Player, event.Pokemon, event.Move, event.Effect, event.Target)
+	case engine.EventUse:
+		return fmt.Sprintf("%s used a %s on %s: +%d HP (HP: %d)", event.Player, event.Effect, event.Pokemon, event.Damage, event.Hp)
+	}
+	change := map[int]string{-2: "harshly fell", -1: "fell", 1: "rose", 2: "sharply rose"}[event.Change]
+	if event.Change == 0 {
+		change = "won't go any further"
+	}
+	target := event.Target + "'s " + event.TargetPokemon
+	if event.Target == event.Player && event.TargetPokemon == event.Pokemon {
+		target = "its"
+	} else {
+		target += "'s"
+	}
+	return fmt.Sprintf("%s's %s used %s: %s %s %s!", event.Player, event.Pokemon, event.Move, target, event.Effect, change)
+}
+
+// turnMessage shows a player the field and the actions they can choose
+func turnMessage(state engine.BattleState, player string) string {
+	if state.Format == engine.Doubles {
+		return fmt.Sprintf("Turn %d. Your pokemons: %s | Opponent: %s\nChoose an action for each slot: @attack slot 1|2|all or @change slot pokemonID",
+			state.Turn+1, describeSlots(state, player), describeSlots(state, state.Opponent(player)))
+	}
+	msg := fmt.Sprintf("Turn %d. Your pokemon: %s", state.Turn+1, describeSlots(state, player))
+	for _, name := range state.Teammates(player) {
+		msg += fmt.Sprintf(" | Teammate %s: %s", name, describeSlots(state, name))
+	}
+	var opponents, targets []string
+	for _, name := range state.Opponents(player) {
+		if len(state.Remaining(name)) > 0 {
+			opponents = append(opponents, name+": "+describeSlots(state, name))
+			targets = append(targets, name)
+		}
+	}
+	return msg + fmt.Sprintf(" | Opponents: %s\nChoose your action: @attack [%s|all] or @change pokemonID",
+		strings.Join(opponents, ", "), strings.Join(targets, "|"))
+}
+
+// plannedActionMessage confirms an action that waits for the rest of the turn
+func plannedActionMessage(battle *Battle, action engine.Action) string {
+	var plan string
+	switch {
+	case action.Kind == engine.ActionChange:
+		plan = "switch to " + action.PokemonID
+	case action.Kind == engine.ActionItem && action.PokemonID != "":
+		plan = "use " + action.Item + " on " + action.PokemonID
+	case action.Kind == engine.ActionItem:
+		plan = "use " + action.Item
+	case action.Kind == engine.ActionMove && action.Target == "":
+		plan = "use " + action.Move
+	case action.Kind == engine.ActionMove && action.Target == "all":
+		plan = "use " + action.Move + " against every opponent"
+	case action.Kind == engine.ActionMove && battle.Format == engine.Doubles:
+		plan = "use " + action.Move + " against the opponent's slot " + action.Target
+	case action.Kind == engine.ActionMove:
+		plan = "use " + action.Move + " against " + action.Target
+	case action.Target == "all" && battle.Format == engine.Doubles:
+		plan = "attack both opponent's pokemons"
+	case action.Target == "all":
+		plan = "attack every opponent"
+	case battle.Format == engine.Doubles:
+		plan = "attack the opponent's slot " + action.Target
+	case action.Target == "":
+		plan = "attack"
+	default:
+		plan = "attack " + action.Target
+	}
+	if battle.Format == engine.Doubles {
+		return fmt.Sprintf("Slot %d will %s, waiting for the other actions...", action.Slot, plan)
+	}
+	return fmt.Sprintf("You will %s, waiting for the other players...", plan)
+}
+
+// sideNames lists the sides of a battle, "alice+bob" for a team
+func sideNames(state engine.BattleState) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range state.Players {
+		if side := state.Sides[name]; !seen[side] {
+			seen[side] = true
+			names = append(names, side)
+		}
+	}
+	return names
+}
+
+// describeSlots lists the pokemons a player has in the field, with their
+// slot in doubles
+func describeSlots(state engine.BattleState, player string) string {
+	if len(state.Slots[player]) == 1 {
+		if p := state.SlotPokemon(player, 1); p != nil {
+			return fmt.Sprintf("%s (HP: %d)", p.Name, p.Hp)
+		}
+		return "-"
+	}
+	var slots []string
+	for slot := 1; slot <= len(state.Slots[player]); slot++ {
+		if p := state.SlotPokemon(player, slot); p != nil {
+			slots = append(slots, fmt.Sprintf("%d) %s (HP: %d)", slot, p.Name, p.Hp))
+		} else {
+			slots = append(slots, fmt.Sprintf("%d) -", slot))
+		}
+	}
+	return strings.Join(slots, " ")
+}
+
+// forcedSwitchMessage lists the pokemons a player can send after a faint
+func forcedSwitchMessage(battle *Battle, player string) string {
+	msg := "@forced_switch"
+	pokemons := battle.State.Remaining(player)
+	if battle.State.Slots != nil {
+		pokemons = battle.State.Bench(player)
+	}
+	for _, p := range pokemons {
+		msg += fmt.Sprintf("Pokemon ID: %s, Name: %s, HP: %d\n", p.ID, p.Name, p.Hp)
+	}
+	return msg
+}
+
+func notYourTurnMessage(battle *Battle, player string) string {
+	for name, forced := range battle.State.ForcedSwitch {
+		if forced && name != player {
+			return "Opponent is choosing a new pokemon, wait for your turn!"
+		}
+	}
+	return "Not your turn!"
+}
@@ -41,7 +41,7 @@ func main() {
 			return
 		}
 
-		buffer := make([]byte, 1024)
+		buffer := make([]byte, 65507) // the largest UDP datagram
 		n, _, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 
@@ -73,7 +73,7 @@ func main() {
 }
 
 func receiveMessages(addr *net.UDPAddr, conn *net.UDPConn) {
-	buffer := make([]byte, 1024)
+	buffer := make([]byte, 65507) // the largest UDP datagram
 
 	for {
 		n, _, err := conn.ReadFromUDP(buffer)
@@ -126,6 +126,19 @@ func receiveMessages(addr *net.UDPAddr, conn *net.UDPConn) {
 			continue
 		}
 
+		if strings.Contains(response, "@forced_switch") {
+			canNotAttack[addr] = true
+			fmt.Println("Your pokemon fainted! Choose the next one (@change pokemonID):")
+			fmt.Println(strings.TrimPrefix(response, "@forced_switch"))
+			continue
+		}
+
+		if strings.Contains(response, "@forced_changed") {
+			fmt.Println("Pokémon changed successfully! It is still your turn!")
+			delete(canNotAttack, addr)
+			continue
+		}
+
 		if strings.Contains(response, "@changed") {
 			fmt.Println("Pokémon changed successfully! Now is the oppenonent's turn!")
 			delete(canNotAttack, addr)
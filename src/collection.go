@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Players manage their collection of pokemons, kept in the player store:
+//
+//   - "@nickname pokemonID name" gives a pokemon a nickname, shown with its
+//     species in the lists, "@nickname pokemonID" takes it back
+//   - "@favorite pokemonID" marks a pokemon as a favorite or unmarks it.
+//     Favorites cannot be released.
+//   - "@release pokemonID" asks to release a pokemon for good,
+//     "@release confirm" releases it
+//   - "@list" shows the pokemons a page at a time, filtered and sorted:
+//     "@list type=water level=5-10 name=gya fav sort=level page=2". The name
+//     matches the species or the nickname, numeric stats sort the highest
+//     first.
+const (
+	listPageSize      = 10
+	maxNicknameLength = 12
+)
+
+// pendingReleases is the pokemon every player asked to release, until they
+// confirm
+var pendingReleases = make(map[string]string)
+
+// listSorts compare two pokemons for "@list sort=key"
+var listSorts = map[string]func(a, b PlayerPokeInfo) bool{
+	"id": func(a, b PlayerPokeInfo) bool { return a.ID < b.ID },
+	"name": func(a, b PlayerPokeInfo) bool {
+		return strings.ToLower(displayName(a)) < strings.ToLower(displayName(b))
+	},
+	"level": func(a, b PlayerPokeInfo) bool { return a.Level > b.Level },
+	"hp":    func(a, b PlayerPokeInfo) bool { return injuredHp(&a) > injuredHp(&b) },
+	"atk":   func(a, b PlayerPokeInfo) bool { return a.Atk > b.Atk },
+	"def":   func(a, b PlayerPokeInfo) bool { return a.Def > b.Def },
+	"spatk": func(a, b PlayerPokeInfo) bool { return a.SpAtk > b.SpAtk },
+	"spdef": func(a, b PlayerPokeInfo) bool { return a.SpDef > b.SpDef },
+	"speed": func(a, b PlayerPokeInfo) bool { return a.Speed > b.Speed },
+}
+
+// displayName is the name of a pokemon of a player in the lists, with its
+// nickname if it has one
+func displayName(p PlayerPokeInfo) string {
+	if p.Nickname == "" {
+		return p.Name
+	}
+	return p.Nickname + " (" + p.Name + ")"
+}
+
+// describeListed is the line of a pokemon in "@list"
+func describeListed(p PlayerPokeInfo) string {
+	favorite := ""
+	if p.Favorite {
+		favorite = " *"
+	}
+	return fmt.Sprintf("Pokemon ID: %s, Name: %s%s, Level: %d, HP: %s%s", p.ID, displayName(p), favorite, p.Level, describeHp(p), describeHeld(p))
+}
+
+// listPokemons shows a page of the pokemons of a player that pass the
+// filters of "@list"
+func listPokemons(player string, args []string) (string, error) {
+	var (
+		types          string
+		name           string
+		favorites      bool
+		minLvl, maxLvl = 0, -1
+		sortKey        = "id"
+		page           = 1
+	)
+	for _, arg := range args {
+		key, value := arg, ""
+		if i := strings.Index(arg, "="); i >= 0 {
+			key, value = arg[:i], arg[i+1:]
+		}
+		switch key {
+		case "type":
+			types = strings.ToLower(value)
+		case "name":
+			name = strings.ToLower(value)
+		case "fav", "favorites":
+			favorites = true
+		case "level":
+			low, high := value, value
+			if i := strings.Index(value, "-"); i >= 0 {
+				low, high = value[:i], value[i+1:]
+			}
+			var err error
+			if low != "" {
+				if minLvl, err = strconv.Atoi(low); err != nil {
+					return "", fmt.Errorf("invalid level %s, use level=min-max", value)
+				}
+			}
+			if high != "" {
+				if maxLvl, err = strconv.Atoi(high); err != nil {
+					return "", fmt.Errorf("invalid level %s, use level=min-max", value)
+				}
+			}
+		case "sort":
+			if listSorts[value] == nil {
+				return "", fmt.Errorf("cannot sort by %s, use id, name, level, hp, atk, def, spatk, spdef or speed", value)
+			}
+			sortKey = value
+		case "page":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("invalid page %s", value)
+			}
+			page = n
+		default:
+			return "", fmt.Errorf("unknown filter %s, use type=, level=, name=, fav, sort= or page=", arg)
+		}
+	}
+
+	var list []PlayerPokeInfo
+	for _, p := range findPlayerPokemonByPlayer(player) {
+		switch {
+		case types != "" && !includesType(speciesTypes(&p), types),
+			name != "" && !strings.Contains(strings.ToLower(displayName(p)), name),
+			favorites && !p.Favorite,
+			p.Level < minLvl, maxLvl >= 0 && p.Level > maxLvl:
+			continue
+		}
+		list = append(list, p)
+	}
+	if len(list) == 0 {
+		return "No pokemon found.", nil
+	}
+	less := listSorts[sortKey]
+	sort.SliceStable(list, func(i, j int) bool {
+		if less(list[i], list[j]) != less(list[j], list[i]) {
+			return less(list[i], list[j])
+		}
+		return list[i].ID < list[j].ID
+	})
+	pages := (len(list) + listPageSize - 1) / listPageSize
+	if page > pages {
+		return "", fmt.Errorf("there are only %d pages", pages)
+	}
+	lines := []string{fmt.Sprintf("%d pokemons, page %d/%d:", len(list), page, pages)}
+	end := page * listPageSize
+	if end > len(list) {
+		end = len(list)
+	}
+	for _, p := range list[(page-1)*listPageSize : end] {
+		lines = append(lines, describeListed(p))
+	}
+	if page < pages {
+		lines = append(lines, fmt.Sprintf("Next page: @list %s", strings.Join(append(withoutPage(args), "page="+strconv.Itoa(page+1)), " ")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// includesType tells if a list of types has a type, whatever its case
+func includesType(types []string, t string) bool {
+	for _, have := range types {
+		if strings.ToLower(have) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutPage drops the page of the @list arguments
+func withoutPage(args []string) []string {
+	var kept []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "page=") {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// setNickname gives a pokemon of a player a nickname, or takes it back when
+// the nickname is empty
+func setNickname(player string, id string, nickname string) (string, error) {
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	if len([]rune(nickname)) > maxNicknameLength {
+		return "", fmt.Errorf("a nickname has at most %d letters", maxNicknameLength)
+	}
+	for _, r := range nickname {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '.' {
+			return "", fmt.Errorf("a nickname has only letters, digits, spaces, - and .")
+		}
+	}
+	p.Nickname = nickname
+	if nickname == "" {
+		return fmt.Sprintf("%s has no nickname now.", id), nil
+	}
+	return fmt.Sprintf("%s %s is now called %s.", id, p.Name, nickname), nil
+}
+
+// toggleFavorite marks a pokemon of a player as a favorite, or unmarks it
+func toggleFavorite(player string, id string) (string, error) {
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	p.Favorite = !p.Favorite
+	if p.Favorite {
+		return fmt.Sprintf("%s is one of your favorites now.", displayName(*p)), nil
+	}
+	return fmt.Sprintf("%s is not one of your favorites anymore.", displayName(*p)), nil
+}
+
+// releaseCommand implements "@release pokemonID" and "@release confirm"
+func releaseCommand(args string, senderName string, conn *net.UDPConn) {
+	addr := players[senderName].Addr
+	id := strings.TrimSpace(args)
+	if id == "" {
+		sendMessage("Usage: @release pokemonID, then @release confirm", addr, conn)
+		return
+	}
+	if id != "confirm" {
+		if err := checkRelease(senderName, id); err != nil {
+			sendMessage("Error: "+err.Error(), addr, conn)
+			return
+		}
+		pendingReleases[senderName] = id
+		p := ownedPokemon(senderName, id)
+		sendMessage(fmt.Sprintf("Release %s %s (level %d) for good? It cannot come back. Answer with @release confirm.", id, displayName(*p), p.Level), addr, conn)
+		return
+	}
+	id, ok := pendingReleases[senderName]
+	if !ok {
+		sendMessage("Error: Choose the pokemon first with @release pokemonID", addr, conn)
+		return
+	}
+	delete(pendingReleases, senderName)
+	if err := checkRelease(senderName, id); err != nil { // it can have changed since
+		sendMessage("Error: "+err.Error(), addr, conn)
+		return
+	}
+	record := findPlayerRecord(senderName)
+	var name string
+	for i, p := range record.PlayerPokeInfo {
+		if p.ID == id {
+			name = displayName(p)
+			if p.Item != "" {
+				if key, item := findBagItem(p.Item); item != nil {
+					addToBag(record, key, 1)
+				}
+			}
+			record.PlayerPokeInfo = append(record.PlayerPokeInfo[:i], record.PlayerPokeInfo[i+1:]...)
+			break
+		}
+	}
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+	sendMessage(fmt.Sprintf("You released %s %s. Bye bye!", id, name), addr, conn)
+}
+
+// checkRelease tells why a pokemon of a player cannot be released
+func checkRelease(player string, id string) error {
+	p := ownedPokemon(player, id)
+	switch {
+	case p == nil:
+		return fmt.Errorf("you have no pokemon %s", id)
+	case p.Favorite:
+		return fmt.Errorf("%s is one of your favorites, @favorite %s first", id, id)
+	case len(findPlayerPokemonByPlayer(player)) <= 3:
+		return fmt.Errorf("you need at least 3 pokemons to battle")
+	}
+	if t := trades[player]; t != nil {
+		for _, offered := range t.Offers[player].Pokemons {
+			if offered == id {
+				return fmt.Errorf("%s is in your trade offer", id)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// collectionRecords gives ash 12 pokemons, more than a page
+func collectionRecords() []PlayerPokemon {
+	pokemons := []PlayerPokeInfo{
+		{Name: "Pikachu", Level: 12, Hp: 35, Speed: 90, Types: []string{"Electric"}, Favorite: true},
+		{Name: "Squirtle", Level: 5, Hp: 44, Speed: 43, Types: []string{"Water"}, Nickname: "Shelly"},
+		{Name: "Gyarados", Level: 30, Hp: 95, Speed: 81, Types: []string{"Water", "Flying"}},
+		{Name: "Magikarp", Level: 8, Hp: 20, Speed: 80, Types: []string{"Water"}, Favorite: true},
+	}
+	for len(pokemons) < 12 {
+		pokemons = append(pokemons, PlayerPokeInfo{Name: "Rattata", Level: 3, Hp: 30, Speed: 72, Types: []string{"Normal"}})
+	}
+	for i := range pokemons {
+		pokemons[i].ID = fmt.Sprintf("#%03d", i+1)
+	}
+	return []PlayerPokemon{{Owner: "ash", PlayerPokeInfo: pokemons}}
+}
+
+// listedIDs gives the IDs of the pokemons of a page of @list
+func listedIDs(page string) []string {
+	var ids []string
+	for _, line := range strings.Split(page, "\n") {
+		if strings.HasPrefix(line, "Pokemon ID: ") {
+			ids = append(ids, strings.SplitN(strings.TrimPrefix(line, "Pokemon ID: "), ",", 2)[0])
+		}
+	}
+	return ids
+}
+
+func TestListPokemons(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+		next string // the next page line, none when empty
+	}{
+		{[]string{"type=water"}, []string{"#002", "#003", "#004"}, ""},
+		{[]string{"type=Flying"}, []string{"#003"}, ""},
+		{[]string{"level=5-10"}, []string{"#002", "#004"}, ""},
+		{[]string{"level=10-"}, []string{"#001", "#003"}, ""},
+		{[]string{"name=shel"}, []string{"#002"}, ""},
+		{[]string{"name=SQUIRT"}, []string{"#002"}, ""},
+		{[]string{"fav"}, []string{"#001", "#004"}, ""},
+		{[]string{"type=water", "sort=level"}, []string{"#003", "#004", "#002"}, ""},
+		{[]string{"level=5-", "sort=speed"}, []string{"#001", "#003", "#004", "#002"}, ""},
+		{[]string{"level=5-", "sort=name"}, []string{"#003", "#004", "#001", "#002"}, ""},
+		{nil, []string{"#001", "#002", "#003", "#004", "#005", "#006", "#007", "#008", "#009", "#010"}, "Next page: @list page=2"},
+		{[]string{"sort=level", "page=1"}, []string{"#003", "#001", "#004", "#002", "#005", "#006", "#007", "#008", "#009", "#010"}, "Next page: @list sort=level page=2"},
+		{[]string{"page=2"}, []string{"#011", "#012"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			useRecords(t, collectionRecords())
+			page, err := listPokemons("ash", tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listedIDs(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+			lines := strings.Split(page, "\n")
+			if next := lines[len(lines)-1]; strings.HasPrefix(next, "Next page") != (tt.next != "") || tt.next != "" && next != tt.next {
+				t.Errorf("last line %q, want %q", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestListPokemonsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"page=3"}, "there are only 2 pages"},
+		{[]string{"page=0"}, "invalid page 0"},
+		{[]string{"level=x"}, "invalid level x"},
+		{[]string{"sort=luck"}, "cannot sort by luck"},
+		{[]string{"color=red"}, "unknown filter color=red"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			useRecords(t, collectionRecords())
+			if _, err := listPokemons("ash", tt.args); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+	useRecords(t, collectionRecords())
+	if page, err := listPokemons("ash", []string{"type=fire"}); err != nil || page != "No pokemon found." {
+		t.Errorf("got %q, %v for no match", page, err)
+	}
+}
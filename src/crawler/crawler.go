@@ -14,11 +14,12 @@ import (
 )
 
 type Pokedex struct {
-	Id       string   `json:"ID"`
-	Name     string   `json:"Name"`
-	Types    []string `json:"types"`
-	Link     string   `json:"URL"`
-	PokeInfo PokeInfo `json:"Poke-Information"`
+	Id        string   `json:"ID"`
+	Name      string   `json:"Name"`
+	Types     []string `json:"types"`
+	Abilities []string `json:"Abilities,omitempty"`
+	Link      string   `json:"URL"`
+	PokeInfo  PokeInfo `json:"Poke-Information"`
 }
 type PokeInfo struct {
 	Hp          int     `json:"HP"`
@@ -88,7 +89,7 @@ func main() {
 
 	for _, poke := range pokemons {
 
-		allPokeInfo = getDetail(poke.Link)
+		allPokeInfo, poke.Abilities = getDetail(poke.Link)
 		poke.PokeInfo = allPokeInfo
 		allPoke = append(allPoke, poke)
 		allPokeInfo = PokeInfo{}
@@ -148,7 +149,7 @@ func getPokedex(n *html.Node) []Pokedex {
 	return pokemon
 }
 
-func getDetail(url string) PokeInfo {
+func getDetail(url string) (PokeInfo, []string) {
 	resp, err := http.Get("https://pokemondb.net" + url)
 	if err != nil {
 		fmt.Println("Error fetching WEBTOON homepage: ", err)
@@ -166,6 +167,7 @@ func getDetail(url string) PokeInfo {
 		os.Exit(1)
 	}
 	var pokeInfo PokeInfo
+	var abilities []string
 	var walk func(n *html.Node)
 	walk = func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "table" {
@@ -173,6 +175,9 @@ func getDetail(url string) PokeInfo {
 				if attr.Key == "class" && attr.Val == "vitals-table" {
 					result := getOnce(n, "th")
 					stats := strings.Split(result, " ")
+					if len(abilities) == 0 { // the first table is the one of the default form
+						abilities = getAbilities(n)
+					}
 					for _, st := range stats {
 						if st == "HP" {
 							listStat := getStatNumber(n, "td", "class", "cell-num")
@@ -245,7 +250,22 @@ func getDetail(url string) PokeInfo {
 		}
 	}
 	walk(doc)
-	return pokeInfo
+	return pokeInfo, abilities
+}
+
+// getAbilities takes the names of the ability links ("/ability/overgrow"),
+// the hidden ability comes last
+func getAbilities(n *html.Node) []string {
+	var abilities []string
+	if n.Type == html.ElementNode && n.Data == "a" && strings.HasPrefix(getStringElement(n, "a", "href"), "/ability/") {
+		if name := strings.TrimSpace(getOnce(n, "a")); name != "" {
+			abilities = append(abilities, name)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		abilities = append(abilities, getAbilities(c)...)
+	}
+	return abilities
 }
 
 func getInsideTag(n *html.Node, data, key, val string) string {
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// In a draft (rules with Draft set) the players see every roster, then ban
+// species and pick their pokemons one at a time, in turns, instead of
+// sending @pick with their whole team. Every ban and pick is told to
+// everybody. The player who was challenged starts:
+//
+//	bans:  thien, anh, ... (Bans per player, a banned species cannot be picked by anyone)
+//	picks: thien, anh, thien, anh, ... (TeamSize per player)
+//
+// A player who runs out of time skips their ban, or gets the first pokemon
+// of their list they are allowed to pick.
+type Draft struct {
+	Order  []string            // players in turn order
+	Step   int                 // bans then picks played so far
+	Banned []string            // banned species
+	Picked map[string][]string // IDs picked by each player, in pick order
+}
+
+var draftTurnTimeout = flag.Duration("draft-turn-timeout", 30*time.Second, "time a player has to @ban or @pick on their draft turn")
+
+// startDraft shows every roster to the players and starts the first turn
+func startDraft(battle *Battle, conn *net.UDPConn) {
+	draft := &Draft{Picked: make(map[string][]string)}
+	for _, name := range battlePlayerNames(battle) {
+		if name != battle.Challenger {
+			draft.Order = append(draft.Order, name)
+		}
+	}
+	draft.Order = append(draft.Order, battle.Challenger)
+	battle.draft = draft
+
+	for _, name := range draft.Order {
+		for _, other := range draft.Order {
+			owner := other + "'s"
+			if other == name {
+				owner = "Your"
+			}
+			sendMessage(fmt.Sprintf("%s roster: %s", owner, describeRoster(other)), playerAddr(name), conn)
+		}
+		sendMessage("Rules "+battle.Rules.String(), playerAddr(name), conn)
+	}
+	nextDraftTurn(battle, conn)
+}
+
+// describeRoster lists the pokemons a player can draft
+func describeRoster(player string) string {
+	var roster []string
+	for _, p := range findPlayerPokemonByPlayer(player) {
+		roster = append(roster, fmt.Sprintf("%s %s (Lv. %d, HP: %d)", p.ID, p.Name, p.Level, p.Hp))
+	}
+	if len(roster) == 0 {
+		return "no pokemon"
+	}
+	return strings.Join(roster, ", ")
+}
+
+// turn gives the player on turn and whether they ban or pick, done is true
+// once every pick was made
+func (d *Draft) turn(rules Ruleset) (player string, banning bool, done bool) {
+	bans := rules.Bans * len(d.Order)
+	switch {
+	case d.Step < bans:
+		return d.Order[d.Step%len(d.Order)], true, false
+	case d.Step < bans+rules.TeamSize*len(d.Order):
+		return d.Order[(d.Step-bans)%len(d.Order)], false, false
+	}
+	return "", false, true
+}
+
+// nextDraftTurn tells the player on turn what to do and starts their timer,
+// or starts the fight once the draft is over
+func nextDraftTurn(battle *Battle, conn *net.UDPConn) {
+	player, banning, done := battle.draft.turn(battle.Rules)
+	if done {
+		for _, name := range battle.draft.Order {
+			battle.Picks[name], _ = battle.Rules.checkTeam(name, battle.draft.Picked[name])
+		}
+		startFight(battle, conn)
+		return
+	}
+
+	what, usage := "pick a pokemon", fmt.Sprintf("(%d/%d, @pick pokemonID)", len(battle.draft.Picked[player])+1, battle.Rules.TeamSize)
+	if banning {
+		what, usage = "ban a species", "(@ban name)"
+	}
+	sendMessage(fmt.Sprintf("Your turn to %s %s, you have %d seconds!", what, usage, int(draftTurnTimeout.Seconds())), playerAddr(player), conn)
+	for _, name := range battle.draft.Order {
+		if name != player {
+			sendMessage("Waiting for "+player+" to "+what+"...", playerAddr(name), conn)
+		}
+	}
+
+	scheduleTimer(battle, *draftTurnTimeout, func() {
+		sendMessage(fmt.Sprintf("Only %d seconds left for your draft turn!", int(timeoutWarning.Seconds())), playerAddr(player), conn)
+	}, func() {
+		if battle.Status != BattlePicking {
+			return
+		}
+		if banning {
+			tellDraft(battle, player+" did not ban in time.", conn)
+			battle.draft.Step++
+			nextDraftTurn(battle, conn)
+			return
+		}
+		for _, p := range findPlayerPokemonByPlayer(player) {
+			if draftPickError(battle, player, p.ID) == nil {
+				sendMessage("Time is up! A pokemon was picked for you.", playerAddr(player), conn)
+				draftPick(battle, player, p.ID, conn)
+				return
+			}
+		}
+		tellDraft(battle, player+" has no pokemon left to pick.", conn)
+		abortBattle(battle, conn)
+	})
+}
+
+// draftBan bans a species from the draft if it is player's turn to ban
+func draftBan(battle *Battle, player string, name string, conn *net.UDPConn) {
+	addr := playerAddr(player)
+	current, banning, done := battle.draft.turn(battle.Rules)
+	if done || current != player || !banning {
+		sendMessage("It is not your turn to ban!", addr, conn)
+		return
+	}
+	species := findPokemonByNameOrID(name)
+	if species == nil {
+		sendMessage("Error: No pokemon named "+name+" in the pokedex!", addr, conn)
+		return
+	}
+	for _, banned := range battle.draft.Banned {
+		if banned == species.Name {
+			sendMessage("Error: "+species.Name+" is already banned!", addr, conn)
+			return
+		}
+	}
+	battle.draft.Banned = append(battle.draft.Banned, species.Name)
+	tellDraft(battle, player+" banned "+species.Name+".", conn)
+	battle.draft.Step++
+	nextDraftTurn(battle, conn)
+}
+
+// draftPick adds a pokemon to player's team if it is their turn to pick
+func draftPick(battle *Battle, player string, id string, conn *net.UDPConn) {
+	if err := draftPickError(battle, player, id); err != nil {
+		sendMessage("Invalid pokemons selection: "+err.Error(), playerAddr(player), conn)
+		return
+	}
+	battle.draft.Picked[player] = append(battle.draft.Picked[player], id)
+	p := findPlayerPokemonByPokeID(player, id)
+	tellDraft(battle, fmt.Sprintf("%s picked %s (Lv. %d, HP: %d).", player, p.Name, p.Level, p.Hp), conn)
+	battle.draft.Step++
+	nextDraftTurn(battle, conn)
+}
+
+// draftPickError tells why player cannot pick a pokemon now, nil if they can
+func draftPickError(battle *Battle, player string, id string) error {
+	current, banning, done := battle.draft.turn(battle.Rules)
+	if done || current != player || banning {
+		return fmt.Errorf("it is not your turn to pick")
+	}
+	if p := findPlayerPokemonByPokeID(player, id); p != nil {
+		for _, banned := range battle.draft.Banned {
+			if strings.EqualFold(banned, p.Name) {
+				return fmt.Errorf("%s is banned in this draft", p.Name)
+			}
+		}
+	}
+	_, err := battle.Rules.checkTeam(player, append(append([]string(nil), battle.draft.Picked[player]...), id))
+	return err
+}
+
+// tellDraft tells a ban or pick to the players and the spectators
+func tellDraft(battle *Battle, message string, conn *net.UDPConn) {
+	for _, name := range battle.draft.Order {
+		sendMessage(message, playerAddr(name), conn)
+	}
+	notifySpectators(battle, message, conn)
+}
@@ -0,0 +1,435 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// Abilities and held items are effects a pokemon brings to the battle. An
+// effect has hooks the engine calls at fixed points of the battle:
+//
+//	switchIn      the pokemon comes into the field, at the start or by a switch
+//	beforeDamage  an attack of or against the pokemon is about to land, the hook may change the damage
+//	afterDamage   an attack of or against the pokemon landed
+//	endOfTurn     the end of the round, with the field effects
+//	accuracy      an attack of or against the pokemon rolls its accuracy, the hook gives a multiplier
+//
+// critStages are added to the critical hit stage of the holder's attacks.
+//
+// The hooks of the ability run before the ones of the item. The damage of an
+// effect (Rough Skin, Life Orb...) never knocks a pokemon out, and no effect
+// uses the rng, so battles without abilities and items play as before.
+type Effect struct {
+	Name         string
+	Description  string
+	switchIn     func(e *Engine, state *BattleState, self fighter) []Event
+	beforeDamage func(e *Engine, state *BattleState, self fighter, h *hit) []Event
+	afterDamage  func(e *Engine, state *BattleState, self fighter, h *hit) []Event
+	endOfTurn    func(e *Engine, state *BattleState, self fighter) []Event
+	accuracy     func(state *BattleState, self fighter, h *hit) float64
+	critStages   int
+}
+
+// fighter is a pokemon in the field, slot is 0 in singles
+type fighter struct {
+	player string
+	slot   int
+	p      *BattlePokemon
+}
+
+// hit is an attack about to land or that landed
+type hit struct {
+	attacker fighter
+	target   fighter
+	typ      string // see attackType
+	dmg      int
+}
+
+// Abilities and HeldItems are the effects by key, see EffectKey
+var Abilities = map[string]Effect{
+	"intimidate": {Name: "Intimidate", Description: "lowers the attack of the opponents by one stage when it comes in",
+		switchIn: func(e *Engine, state *BattleState, self fighter) []Event {
+			var events []Event
+			for _, foe := range state.foes(self.player) {
+				e.raise(self, foe, "attack", -1, "")
+				events = append(events, Event{Kind: EventAbility, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: "Intimidate",
+					Target: foe.player, TargetPokemon: foe.p.Name, TargetSlot: foe.slot, At: e.clock()})
+			}
+			return events
+		}},
+	"drizzle":       fieldAbility("Drizzle", "rain"),
+	"drought":       fieldAbility("Drought", "sun"),
+	"sandstream":    fieldAbility("Sand Stream", "sandstorm"),
+	"snowwarning":   fieldAbility("Snow Warning", "hail"),
+	"electricsurge": fieldAbility("Electric Surge", "electric terrain"),
+	"grassysurge":   fieldAbility("Grassy Surge", "grassy terrain"),
+	"psychicsurge":  fieldAbility("Psychic Surge", "psychic terrain"),
+	"mistysurge":    fieldAbility("Misty Surge", "misty terrain"),
+	"blaze":         pinchAbility("Blaze", "Fire"),
+	"torrent":       pinchAbility("Torrent", "Water"),
+	"overgrow":      pinchAbility("Overgrow", "Grass"),
+	"swarm":         pinchAbility("Swarm", "Bug"),
+	"thickfat": {Name: "Thick Fat", Description: "halves the damage of fire and ice attacks",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.target.p == self.p && (h.typ == "Fire" || h.typ == "Ice") {
+				h.dmg /= 2
+			}
+			return nil
+		}},
+	"multiscale": {Name: "Multiscale", Description: "halves the damage taken at full HP",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.target.p == self.p && self.p.Hp == self.p.MaxHp {
+				h.dmg /= 2
+			}
+			return nil
+		}},
+	"filter": {Name: "Filter", Description: "super effective attacks do 3/4 of the damage",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.target.p == self.p && typeDefense(self.p, h.typ) > 1 {
+				h.dmg = h.dmg * 3 / 4
+			}
+			return nil
+		}},
+	"levitate": {Name: "Levitate", Description: "ground attacks do nothing",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.target.p != self.p || h.typ != "Ground" || h.dmg == 0 {
+				return nil
+			}
+			h.dmg = 0
+			return []Event{{Kind: EventAbility, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: "Levitate", Hp: self.p.Hp, At: e.clock()}}
+		}},
+	"sturdy": {Name: "Sturdy", Description: "survives a knockout hit with 1 HP when at full HP",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if !endures(self, h) {
+				return nil
+			}
+			return []Event{{Kind: EventAbility, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: "Sturdy", Hp: 1, At: e.clock()}}
+		}},
+	"roughskin": contactAbility("Rough Skin"),
+	"ironbarbs": contactAbility("Iron Barbs"),
+	"speedboost": {Name: "Speed Boost", Description: "raises the speed by one stage at the end of every round",
+		endOfTurn: func(e *Engine, state *BattleState, self fighter) []Event {
+			if e.raise(self, self, "speed", 1, "").Change == 0 {
+				return nil
+			}
+			return []Event{{Kind: EventAbility, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: "Speed Boost", Hp: self.p.Hp, At: e.clock()}}
+		}},
+	"raindish":  weatherHealAbility("Rain Dish", "rain"),
+	"icebody":   weatherHealAbility("Ice Body", "hail"),
+	"superluck": {Name: "Super Luck", Description: "raises the critical hit ratio by one stage", critStages: 1},
+	"compoundeyes": {Name: "Compound Eyes", Description: "raises the accuracy by 30%",
+		accuracy: func(state *BattleState, self fighter, h *hit) float64 {
+			if h.attacker.p == self.p {
+				return 1.3
+			}
+			return 1
+		}},
+	"sandveil":  weatherEvasionAbility("Sand Veil", "sandstorm"),
+	"snowcloak": weatherEvasionAbility("Snow Cloak", "hail"),
+}
+
+// HeldItems are given to pokemons with @hold
+var HeldItems = map[string]Effect{
+	"leftovers": {Name: "Leftovers", Description: "heals 1/16 of max HP at the end of every round",
+		endOfTurn: func(e *Engine, state *BattleState, self fighter) []Event {
+			return e.heal(self, self.p.MaxHp/16, "Leftovers")
+		}},
+	"blacksludge": {Name: "Black Sludge", Description: "heals poison pokemons 1/16 of max HP at the end of every round, hurts the others for 1/8",
+		endOfTurn: func(e *Engine, state *BattleState, self fighter) []Event {
+			if hasType(self.p, "Poison") {
+				return e.heal(self, self.p.MaxHp/16, "Black Sludge")
+			}
+			return e.hurt(self, self.p.MaxHp/8, "Black Sludge")
+		}},
+	"sitrusberry": berry("Sitrus Berry", "heals 1/4 of max HP once, at half HP or less", func(p *BattlePokemon) int { return p.MaxHp / 4 }),
+	"oranberry":   berry("Oran Berry", "heals 10 HP once, at half HP or less", func(p *BattlePokemon) int { return 10 }),
+	"focussash": {Name: "Focus Sash", Description: "survives a knockout hit with 1 HP once when at full HP",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if !endures(self, h) {
+				return nil
+			}
+			self.p.Item = ""
+			return []Event{{Kind: EventItem, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: "Focus Sash", Hp: 1, At: e.clock()}}
+		}},
+	"lifeorb": {Name: "Life Orb", Description: "attacks do 1.3 times the damage, the holder loses 1/10 of max HP for each hit",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.attacker.p == self.p {
+				h.dmg = h.dmg * 13 / 10
+			}
+			return nil
+		},
+		afterDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.attacker.p != self.p || h.dmg == 0 {
+				return nil
+			}
+			return e.hurt(self, self.p.MaxHp/10, "Life Orb")
+		}},
+	"expertbelt": {Name: "Expert Belt", Description: "super effective attacks do 1.2 times the damage",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.attacker.p == self.p && typeDefense(h.target.p, h.typ) > 1 {
+				h.dmg = h.dmg * 6 / 5
+			}
+			return nil
+		}},
+	"scopelens": {Name: "Scope Lens", Description: "raises the critical hit ratio by one stage", critStages: 1},
+	"widelens": {Name: "Wide Lens", Description: "raises the accuracy by 10%",
+		accuracy: func(state *BattleState, self fighter, h *hit) float64 {
+			if h.attacker.p == self.p {
+				return 1.1
+			}
+			return 1
+		}},
+	"brightpowder": {Name: "Bright Powder", Description: "lowers the accuracy of the attacks against the holder by 10%",
+		accuracy: func(state *BattleState, self fighter, h *hit) float64 {
+			if h.target.p == self.p {
+				return 0.9
+			}
+			return 1
+		}},
+	"rockyhelmet":  {Name: "Rocky Helmet", Description: "attackers lose 1/6 of their max HP", afterDamage: contactDamage("Rocky Helmet", 6)},
+	"charcoal":     typeBooster("Charcoal", "Fire"),
+	"mysticwater":  typeBooster("Mystic Water", "Water"),
+	"miracleseed":  typeBooster("Miracle Seed", "Grass"),
+	"magnet":       typeBooster("Magnet", "Electric"),
+	"nevermeltice": typeBooster("Never-Melt Ice", "Ice"),
+	"blackbelt":    typeBooster("Black Belt", "Fighting"),
+	"softsand":     typeBooster("Soft Sand", "Ground"),
+	"twistedspoon": typeBooster("Twisted Spoon", "Psychic"),
+	"dragonfang":   typeBooster("Dragon Fang", "Dragon"),
+}
+
+// fieldAbility sets a weather or a terrain when the pokemon comes in
+func fieldAbility(name string, field string) Effect {
+	return Effect{Name: name, Description: "sets " + field + " when it comes in",
+		switchIn: func(e *Engine, state *BattleState, self fighter) []Event {
+			set := e.setField(state, self.player, self.p.Name, self.slot, field)
+			set.Pokemon = ""
+			return []Event{{Kind: EventAbility, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: name, Hp: self.p.Hp, At: e.clock()}, set}
+		}}
+}
+
+// pinchAbility boosts attacks of a type by half at a third of max HP or less
+func pinchAbility(name string, typ string) Effect {
+	return Effect{Name: name, Description: strings.ToLower(typ) + " attacks do 1.5 times the damage at 1/3 of max HP or less",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.attacker.p == self.p && h.typ == typ && self.p.Hp*3 <= self.p.MaxHp {
+				h.dmg = h.dmg * 3 / 2
+			}
+			return nil
+		}}
+}
+
+func contactAbility(name string) Effect {
+	return Effect{Name: name, Description: "attackers lose 1/8 of their max HP", afterDamage: contactDamage(name, 8)}
+}
+
+// contactDamage hurts the attackers that did damage to the pokemon for
+// 1/fraction of their max HP
+func contactDamage(name string, fraction int) func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+	return func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+		if h.target.p != self.p || h.dmg == 0 || h.attacker.p.Hp <= 0 {
+			return nil
+		}
+		return e.hurt(h.attacker, h.attacker.p.MaxHp/fraction, name)
+	}
+}
+
+func weatherHealAbility(name string, weather string) Effect {
+	return Effect{Name: name, Description: "heals 1/16 of max HP at the end of every round of " + weather,
+		endOfTurn: func(e *Engine, state *BattleState, self fighter) []Event {
+			if state.Field.Weather != weather {
+				return nil
+			}
+			return e.heal(self, self.p.MaxHp/16, name)
+		}}
+}
+
+// weatherEvasionAbility lowers the accuracy of the attacks against the
+// pokemon by 20% in a weather
+func weatherEvasionAbility(name string, weather string) Effect {
+	return Effect{Name: name, Description: "lowers the accuracy of the attacks against it by 20% in " + weather,
+		accuracy: func(state *BattleState, self fighter, h *hit) float64 {
+			if h.target.p == self.p && state.Field.Weather == weather {
+				return 0.8
+			}
+			return 1
+		}}
+}
+
+// berry heals the pokemon once when an attack leaves it at half HP or less
+func berry(name string, description string, amount func(p *BattlePokemon) int) Effect {
+	return Effect{Name: name, Description: description,
+		afterDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.target.p != self.p || self.p.Hp <= 0 || self.p.Hp*2 > self.p.MaxHp {
+				return nil
+			}
+			self.p.Item = ""
+			return e.heal(self, amount(self.p), name)
+		}}
+}
+
+func typeBooster(name string, typ string) Effect {
+	return Effect{Name: name, Description: strings.ToLower(typ) + " attacks do 1.2 times the damage",
+		beforeDamage: func(e *Engine, state *BattleState, self fighter, h *hit) []Event {
+			if h.attacker.p == self.p && h.typ == typ {
+				h.dmg = h.dmg * 6 / 5
+			}
+			return nil
+		}}
+}
+
+// endures leaves the target of h with 1 HP when the hit would knock it out
+// from full HP
+func endures(self fighter, h *hit) bool {
+	if h.target.p != self.p || self.p.Hp != self.p.MaxHp || h.dmg < self.p.Hp || self.p.Hp <= 1 {
+		return false
+	}
+	h.dmg = self.p.Hp - 1
+	return true
+}
+
+// EffectKey is the key of an ability or an item: "Rough Skin" is "roughskin"
+func EffectKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// FindAbility and FindItem give the effect of a name, nil if it has none
+func FindAbility(name string) *Effect {
+	if a, ok := Abilities[EffectKey(name)]; ok {
+		return &a
+	}
+	return nil
+}
+
+func FindItem(name string) *Effect {
+	if i, ok := HeldItems[EffectKey(name)]; ok {
+		return &i
+	}
+	return nil
+}
+
+// DescribeEffects lists abilities or items with what they do, for help
+// messages
+func DescribeEffects(effects map[string]Effect) string {
+	var lines []string
+	for _, ef := range effects {
+		lines = append(lines, ef.Name+": "+ef.Description)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// effectsOf gives the effects of a pokemon, its ability first
+func effectsOf(p *BattlePokemon) []*Effect {
+	var effects []*Effect
+	if a := FindAbility(p.Ability); a != nil {
+		effects = append(effects, a)
+	}
+	if i := FindItem(p.Item); i != nil {
+		effects = append(effects, i)
+	}
+	return effects
+}
+
+// switchIn hurts a pokemon that came into the field with the entry hazards
+// of its side, then runs its switch-in hooks
+func (e *Engine) switchIn(state *BattleState, self fighter) []Event {
+	events := e.hazards(state, self)
+	for _, ef := range effectsOf(self.p) {
+		if ef.switchIn != nil {
+			events = append(events, ef.switchIn(e, state, self)...)
+		}
+	}
+	return events
+}
+
+// beforeDamage runs the hooks of the attacker, then of the target
+func (e *Engine) beforeDamage(state *BattleState, h *hit) []Event {
+	var events []Event
+	for _, self := range []fighter{h.attacker, h.target} {
+		for _, ef := range effectsOf(self.p) {
+			if ef.beforeDamage != nil {
+				events = append(events, ef.beforeDamage(e, state, self, h)...)
+			}
+		}
+	}
+	return events
+}
+
+// afterDamage runs the hooks of the target, then of the attacker
+func (e *Engine) afterDamage(state *BattleState, h *hit) []Event {
+	var events []Event
+	for _, self := range []fighter{h.target, h.attacker} {
+		for _, ef := range effectsOf(self.p) {
+			if ef.afterDamage != nil {
+				events = append(events, ef.afterDamage(e, state, self, h)...)
+			}
+		}
+	}
+	return events
+}
+
+// endOfTurn runs the end of round hooks of a pokemon in the field
+func (e *Engine) endOfTurn(state *BattleState, self fighter) []Event {
+	var events []Event
+	for _, ef := range effectsOf(self.p) {
+		if ef.endOfTurn != nil && self.p.Hp > 0 {
+			events = append(events, ef.endOfTurn(e, state, self)...)
+		}
+	}
+	return events
+}
+
+// hurt takes HP from a pokemon because of an effect, leaving it at least 1
+func (e *Engine) hurt(self fighter, amount int, name string) []Event {
+	if amount < 1 {
+		amount = 1
+	}
+	if amount >= self.p.Hp {
+		amount = self.p.Hp - 1
+	}
+	if amount <= 0 {
+		return nil
+	}
+	self.p.Hp -= amount
+	return []Event{{Kind: EventResidual, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: name,
+		Damage: amount, Hp: self.p.Hp, At: e.clock()}}
+}
+
+// heal gives HP back to a pokemon because of an effect, up to its max HP
+func (e *Engine) heal(self fighter, amount int, name string) []Event {
+	if amount < 1 {
+		amount = 1
+	}
+	if self.p.Hp+amount > self.p.MaxHp {
+		amount = self.p.MaxHp - self.p.Hp
+	}
+	if amount <= 0 || self.p.Hp <= 0 {
+		return nil
+	}
+	self.p.Hp += amount
+	return []Event{{Kind: EventHeal, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Effect: name,
+		Damage: amount, Hp: self.p.Hp, At: e.clock()}}
+}
+
+// foes lists the pokemons of the opponents of player in the field
+func (s *BattleState) foes(player string) []fighter {
+	var foes []fighter
+	if s.Slots == nil {
+		opponent := s.Opponent(player)
+		if p := s.ActivePokemon(opponent); p != nil {
+			foes = append(foes, fighter{opponent, 0, p})
+		}
+		return foes
+	}
+	for _, pos := range s.targets(player, "all") {
+		foes = append(foes, fighter{pos.player, pos.slot, s.SlotPokemon(pos.player, pos.slot)})
+	}
+	return foes
+}
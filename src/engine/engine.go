@@ -0,0 +1,608 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The battle engine holds the rules of a battle and nothing else. It takes a
+// BattleState and an Action and returns the next BattleState together with the
+// Events that happened, without touching the network, the player store or the
+// global rng. The same seed, clock and actions always give the same battle, so
+// the server, the replays and the simulator all drive this one engine.
+type (
+	Engine struct {
+		rng     *rand.Rand
+		clock   func() time.Time
+		Classic bool // every attack hits and none is critical, to replay battles from before accuracy (replay version 1)
+	}
+
+	BattleState struct {
+		Format       BattleFormat               // singles when empty
+		Players      []string                   // sorted player names
+		Teams        map[string][]BattlePokemon // picked pokemons of each player, in pick order
+		Active       map[string]int             // index in Teams of the pokemon in the field
+		ForcedSwitch map[string]bool            // players who must replace a fainted pokemon before acting
+		CurrentTurn  string                     // empty in doubles, where everybody acts at once
+		Turn         int
+		Winner       string
+		StartedAt    time.Time
+		Field        Field                     // weather and terrain
+		Hazards      map[string]map[string]int // layers of every entry hazard on the side of each player
+		Sides        map[string]string         // simultaneous formats: side of every player, the winner is a side
+		Slots        map[string][]int          // simultaneous formats: index in Teams of the pokemon in each slot, -1 when empty
+		Pending      map[string][]Action       // simultaneous formats: actions chosen for each slot this turn
+	}
+
+	BattleFormat string
+
+	ActionKind string
+
+	Action struct {
+		Player    string
+		Kind      ActionKind
+		PokemonID string // pokemon to send in, for ActionChange, or to use the item on, for ActionItem
+		Slot      int    // simultaneous formats: slot of the acting pokemon, from 1
+		Target    string // opponent's slot in doubles, opponent's name in team battles and free-for-all, or "all" for a spread attack
+		Move      string // for ActionMove, see moves.go
+		Item      string // for ActionItem, see items.go
+	}
+
+	EventKind string
+
+	Event struct {
+		Kind          EventKind
+		Player        string // player the event is about, the attacker for EventHit
+		Pokemon       string
+		Target        string // player who got hit
+		TargetPokemon string
+		Damage        int
+		Hp            int    // HP left of the pokemon that got hit or was sent in
+		Slot          int    // simultaneous formats: slot of Player's pokemon
+		TargetSlot    int    // simultaneous formats: slot of Target's pokemon
+		Effect        string // weather or terrain of field events, stat of EventStat, hazard of EventHazard, ability or item of the others and of EventUse
+		Move          string // move of EventStat, EventProtect, EventFail and EventHazard, empty for an ability
+		Change        int    // stages gained or lost, for EventStat
+		Critical      bool   // EventHit was a critical hit
+		At            time.Time
+	}
+)
+
+const (
+	Singles    BattleFormat = "singles"
+	Doubles    BattleFormat = "doubles"
+	TeamBattle BattleFormat = "team" // 2 vs 2 players
+	FreeForAll BattleFormat = "ffa"  // 3 or 4 players, everybody against everybody
+)
+
+const (
+	ActionAttack  ActionKind = "attack"
+	ActionChange  ActionKind = "change"
+	ActionForfeit ActionKind = "forfeit" // a player left a battle of more than two players
+	ActionMove    ActionKind = "move"    // see moves.go
+	ActionItem    ActionKind = "use"     // a medicine of the player's bag, see items.go
+)
+
+const (
+	EventStart    EventKind = "start"     // Player moves first
+	EventHit      EventKind = "hit"       // Player's Pokemon hit Target's TargetPokemon
+	EventFaint    EventKind = "faint"     // Player's Pokemon fainted
+	EventSwitch   EventKind = "switch"    // Player switched to Pokemon, using their turn
+	EventSendOut  EventKind = "send_out"  // Player replaced a fainted pokemon with Pokemon
+	EventTurn     EventKind = "turn"      // it is Player's turn, everybody's in doubles when Player is empty
+	EventOut      EventKind = "out"       // Player has no pokemon left, the others go on
+	EventField    EventKind = "field"     // Player's Pokemon set the weather or terrain Effect
+	EventFieldEnd EventKind = "field_end" // the weather or terrain Effect ended
+	EventResidual EventKind = "residual"  // Player's Pokemon lost Damage HP to the weather, ability or item Effect
+	EventHeal     EventKind = "heal"      // Player's Pokemon got Damage HP back from the terrain, ability or item Effect
+	EventAbility  EventKind = "ability"   // the ability Effect of Player's Pokemon took effect, on Target's TargetPokemon if any
+	EventItem     EventKind = "item"      // the held item Effect of Player's Pokemon took effect and was used up
+	EventMiss     EventKind = "miss"      // Player's Pokemon missed Target's TargetPokemon
+	EventStat     EventKind = "stat"      // the Move of Player's Pokemon changed the Effect stage of Target's TargetPokemon by Change
+	EventProtect  EventKind = "protect"   // Player's Pokemon protected itself, from Target's TargetPokemon if any
+	EventFail     EventKind = "fail"      // the Move of Player's Pokemon failed
+	EventFlinch   EventKind = "flinch"    // Player's Pokemon flinched and lost its action
+	EventHazard   EventKind = "hazard"    // the Move of Player's Pokemon laid the entry hazard Effect on the side of Target
+	EventUse      EventKind = "use"       // Player used the item Effect on Pokemon, which got Damage HP back
+	EventWin      EventKind = "win"       // Player (a side) won, Target has no pokemon left in 1 vs 1
+)
+
+var (
+	ErrBattleOver     = errors.New("the battle is over")
+	ErrNotYourTurn    = errors.New("not your turn")
+	ErrMustSwitch     = errors.New("your pokemon fainted, you must change first")
+	ErrInvalidPokemon = errors.New("invalid pokemon")
+	ErrInvalidAction  = errors.New("invalid action")
+	ErrNoEffect       = errors.New("the item would have no effect")
+)
+
+// NewEngine creates an engine whose damage rolls come from seed and whose
+// events are stamped with clock
+func NewEngine(seed int64, clock func() time.Time) *Engine {
+	return &Engine{rng: rand.New(rand.NewSource(seed)), clock: clock}
+}
+
+// ParseAction reads a battle command sent by a player. In doubles the
+// commands name the slot that acts: "@attack slot target",
+// "@change slot pokemonID" and "@move slot name [target]", in team battles
+// and free-for-all the target: "@attack target" and "@move name target".
+// "@use item [pokemonID]" ("@use slot item [pokemonID]" in doubles) uses an
+// item of the bag.
+func ParseAction(player string, command string) (Action, error) {
+	parts := strings.Fields(command)
+	switch {
+	case len(parts) == 1 && parts[0] == "@attack":
+		return Action{Player: player, Kind: ActionAttack}, nil
+	case len(parts) == 1 && parts[0] == "@forfeit":
+		return Action{Player: player, Kind: ActionForfeit}, nil
+	case len(parts) == 2 && parts[0] == "@attack":
+		return Action{Player: player, Kind: ActionAttack, Target: parts[1]}, nil
+	case len(parts) == 2 && parts[0] == "@change":
+		return Action{Player: player, Kind: ActionChange, PokemonID: parts[1]}, nil
+	case len(parts) == 2 && parts[0] == "@move":
+		return Action{Player: player, Kind: ActionMove, Move: parts[1]}, nil
+	case len(parts) == 2 && parts[0] == "@use":
+		return Action{Player: player, Kind: ActionItem, Item: parts[1]}, nil
+	case len(parts) == 3 && parts[0] == "@use":
+		if slot, err := strconv.Atoi(parts[1]); err == nil {
+			if slot < 1 {
+				break
+			}
+			return Action{Player: player, Kind: ActionItem, Slot: slot, Item: parts[2]}, nil
+		}
+		return Action{Player: player, Kind: ActionItem, Item: parts[1], PokemonID: parts[2]}, nil
+	case len(parts) == 4 && parts[0] == "@use":
+		slot, err := strconv.Atoi(parts[1])
+		if err != nil || slot < 1 {
+			break
+		}
+		return Action{Player: player, Kind: ActionItem, Slot: slot, Item: parts[2], PokemonID: parts[3]}, nil
+	case len(parts) == 3 && parts[0] == "@move":
+		if slot, err := strconv.Atoi(parts[1]); err == nil {
+			if slot < 1 {
+				break
+			}
+			return Action{Player: player, Kind: ActionMove, Slot: slot, Move: parts[2]}, nil
+		}
+		return Action{Player: player, Kind: ActionMove, Move: parts[1], Target: parts[2]}, nil
+	case len(parts) == 4 && parts[0] == "@move":
+		slot, err := strconv.Atoi(parts[1])
+		if err != nil || slot < 1 {
+			break
+		}
+		return Action{Player: player, Kind: ActionMove, Slot: slot, Move: parts[2], Target: parts[3]}, nil
+	case len(parts) == 3 && (parts[0] == "@attack" || parts[0] == "@change"):
+		slot, err := strconv.Atoi(parts[1])
+		if err != nil || slot < 1 {
+			break
+		}
+		if parts[0] == "@attack" {
+			return Action{Player: player, Kind: ActionAttack, Slot: slot, Target: parts[2]}, nil
+		}
+		return Action{Player: player, Kind: ActionChange, Slot: slot, PokemonID: parts[2]}, nil
+	}
+	return Action{}, fmt.Errorf("%w: %q", ErrInvalidAction, command)
+}
+
+// Command is the battle command that gives this action
+func (a Action) Command() string {
+	switch {
+	case a.Slot > 0 && a.Kind == ActionChange:
+		return fmt.Sprintf("@change %d %s", a.Slot, a.PokemonID)
+	case a.Kind == ActionItem:
+		command := "@use " + a.Item
+		if a.Slot > 0 {
+			command = fmt.Sprintf("@use %d %s", a.Slot, a.Item)
+		}
+		if a.PokemonID != "" {
+			command += " " + a.PokemonID
+		}
+		return command
+	case a.Kind == ActionMove:
+		command := "@move " + a.Move
+		if a.Slot > 0 {
+			command = fmt.Sprintf("@move %d %s", a.Slot, a.Move)
+		}
+		if a.Target != "" {
+			command += " " + a.Target
+		}
+		return command
+	case a.Slot > 0:
+		return fmt.Sprintf("@%s %d %s", a.Kind, a.Slot, a.Target)
+	case a.Kind == ActionChange:
+		return "@change " + a.PokemonID
+	case a.Target != "":
+		return "@attack " + a.Target
+	}
+	return "@" + string(a.Kind)
+}
+
+// Start sends out the first pokemon of every team. The fastest one moves
+// first, on a tie tieBreaker does.
+func (e *Engine) Start(teams map[string][]BattlePokemon, tieBreaker string) (BattleState, []Event) {
+	state := BattleState{
+		Teams:        make(map[string][]BattlePokemon),
+		Active:       make(map[string]int),
+		ForcedSwitch: make(map[string]bool),
+		CurrentTurn:  tieBreaker,
+		StartedAt:    e.clock(),
+	}
+	fastest := -1
+	for name, team := range teams {
+		state.Players = append(state.Players, name)
+		state.Teams[name] = withMaxHp(team)
+		state.Active[name] = 0
+		if team[0].Speed > fastest {
+			fastest = team[0].Speed
+		}
+	}
+	sort.Strings(state.Players)
+
+	var faster []string
+	for _, name := range state.Players {
+		if teams[name][0].Speed == fastest {
+			faster = append(faster, name)
+		}
+	}
+	if len(faster) == 1 {
+		state.CurrentTurn = faster[0]
+	}
+	events := []Event{{Kind: EventStart, Player: state.CurrentTurn, At: e.clock()}}
+	for _, name := range []string{state.CurrentTurn, state.Opponent(state.CurrentTurn)} {
+		events = append(events, e.switchIn(&state, fighter{name, 0, state.ActivePokemon(name)})...)
+	}
+	return state, events
+}
+
+// Apply plays action on state. state is left untouched, the returned state is
+// the battle after the action.
+func (e *Engine) Apply(state BattleState, action Action) (BattleState, []Event, error) {
+	if state.Winner != "" {
+		return state, nil, ErrBattleOver
+	}
+	if state.Slots != nil {
+		return e.applySimultaneous(state, action)
+	}
+	if action.Player != state.CurrentTurn || action.Slot != 0 || action.Target != "" {
+		return state, nil, ErrNotYourTurn
+	}
+	next := state.clone()
+	switch action.Kind {
+	case ActionAttack:
+		if next.ForcedSwitch[action.Player] {
+			return state, nil, ErrMustSwitch
+		}
+		pAtk := next.ActivePokemon(action.Player)
+		events := e.attack(&next, action.Player, nil)
+		pAtk.Volatile.LastMove = ""
+		return next, events, nil
+	case ActionChange:
+		i := next.pokemonIndex(action.Player, action.PokemonID)
+		if i < 0 || next.Teams[action.Player][i].Hp <= 0 || (i == next.Active[action.Player] && !next.ForcedSwitch[action.Player]) {
+			return state, nil, ErrInvalidPokemon
+		}
+		return next, e.change(&next, action.Player, i), nil
+	case ActionMove:
+		if next.ForcedSwitch[action.Player] {
+			return state, nil, ErrMustSwitch
+		}
+		move, ok := findMove(action.Move)
+		if !ok {
+			return state, nil, ErrInvalidAction
+		}
+		pAtk := next.ActivePokemon(action.Player)
+		if move.damaging() {
+			events := e.attack(&next, action.Player, &move)
+			pAtk.Volatile.LastMove = move.Name
+			return next, events, nil
+		}
+		events := e.useMove(&next, fighter{action.Player, 0, pAtk}, move)
+		pAtk.Volatile.LastMove = move.Name
+		next.CurrentTurn = next.Opponent(action.Player)
+		next.Turn++
+		return next, append(events, e.passTurn(&next, next.CurrentTurn)...), nil
+	case ActionItem:
+		if next.ForcedSwitch[action.Player] {
+			return state, nil, ErrMustSwitch
+		}
+		events, err := e.useItem(&next, action)
+		if err != nil {
+			return state, nil, err
+		}
+		next.CurrentTurn = next.Opponent(action.Player)
+		next.Turn++
+		return next, append(events, e.passTurn(&next, next.CurrentTurn)...), nil
+	}
+	return state, nil, ErrInvalidAction
+}
+
+// withMaxHp copies a team, the max HP of every pokemon being its HP when it
+// has none
+func withMaxHp(team []BattlePokemon) []BattlePokemon {
+	team = append([]BattlePokemon(nil), team...)
+	for i := range team {
+		if team[i].MaxHp == 0 {
+			team[i].MaxHp = team[i].Hp
+		}
+	}
+	return team
+}
+
+// passTurn gives the turn to player in singles, after the field effects of
+// the round hit their pokemon. The protection of their pokemon ends, and a
+// flinched pokemon loses the turn.
+func (e *Engine) passTurn(state *BattleState, player string) []Event {
+	events := e.expireField(state)
+	p := state.ActivePokemon(player)
+	if p == nil {
+		return append(events, Event{Kind: EventTurn, Player: player, At: e.clock()})
+	}
+	p.Volatile.Protected = false
+	events = append(events, e.residual(state, player, p, 0)...)
+	if p.Hp > 0 && p.Volatile.Flinched {
+		events = append(events, e.endOfTurn(state, fighter{player, 0, p})...)
+		events = append(events, e.flinch(fighter{player, 0, p}))
+		state.CurrentTurn = state.Opponent(player)
+		state.Turn++
+		return append(events, e.passTurn(state, state.CurrentTurn)...)
+	}
+	if p.Hp > 0 {
+		events = append(events, e.endOfTurn(state, fighter{player, 0, p})...)
+		return append(events, Event{Kind: EventTurn, Player: player, At: e.clock()})
+	}
+	events = append(events, Event{Kind: EventFaint, Player: player, Pokemon: p.Name, At: e.clock()})
+	if len(state.Remaining(player)) == 0 {
+		state.Winner = state.Opponent(player)
+		return append(events, Event{Kind: EventWin, Player: state.Winner, Target: player, At: e.clock()})
+	}
+	state.ForcedSwitch[player] = true
+	return append(events, Event{Kind: EventTurn, Player: player, At: e.clock()})
+}
+
+// attack lets the pokemon of attacker hit the opponent's pokemon, with a
+// damaging move or a plain attack when move is nil, and passes the turn. The
+// owner of a fainted pokemon must switch before acting.
+func (e *Engine) attack(state *BattleState, attacker string, move *Move) []Event {
+	opponent := state.Opponent(attacker)
+	pAtk := state.ActivePokemon(attacker)
+	pRecive := state.ActivePokemon(opponent)
+
+	events := e.strike(state, fighter{attacker, 0, pAtk}, fighter{opponent, 0, pRecive}, false, move)
+	state.CurrentTurn = opponent
+	state.Turn++
+
+	if pRecive.Hp > 0 {
+		return append(events, e.passTurn(state, opponent)...)
+	}
+
+	events = append(events, Event{Kind: EventFaint, Player: opponent, Pokemon: pRecive.Name, At: e.clock()})
+	if len(state.Remaining(opponent)) == 0 {
+		state.Winner = attacker
+		return append(events, Event{Kind: EventWin, Player: attacker, Target: opponent, At: e.clock()})
+	}
+	state.ForcedSwitch[opponent] = true
+	return append(events, e.passTurn(state, opponent)...)
+}
+
+// change sends in the pokemon at index i of player's team. Replacing a fainted
+// pokemon does not use the player's turn, a normal switch does.
+func (e *Engine) change(state *BattleState, player string, i int) []Event {
+	leaveField(&state.Teams[player][state.Active[player]])
+	state.Active[player] = i
+	p := &state.Teams[player][i]
+	if state.ForcedSwitch[player] {
+		delete(state.ForcedSwitch, player)
+		events := []Event{{Kind: EventSendOut, Player: player, Pokemon: p.Name, Hp: p.Hp, At: e.clock()}}
+		return append(events, e.switchIn(state, fighter{player, 0, p})...)
+	}
+	state.CurrentTurn = state.Opponent(player)
+	state.Turn++
+	events := []Event{{Kind: EventSwitch, Player: player, Pokemon: p.Name, Hp: p.Hp, At: e.clock()}}
+	events = append(events, e.switchIn(state, fighter{player, 0, p})...)
+	return append(events, e.passTurn(state, state.CurrentTurn)...)
+}
+
+// damage is the damage of a physical attack (ATK against DEF) or a special
+// one (Sp.Atk boosted by the best type matchup against Sp.Def). A critical
+// hit ignores the attack drops of pAtk and the defense boosts of pRecive.
+func damage(pAtk *BattlePokemon, pRecive *BattlePokemon, physical bool, critical bool) int {
+	atk, def := pAtk.Stages.Atk, pRecive.Stages.Def
+	if !physical {
+		atk, def = pAtk.Stages.SpAtk, pRecive.Stages.SpDef
+	}
+	if critical && atk < 0 {
+		atk = 0
+	}
+	if critical && def > 0 {
+		def = 0
+	}
+	if physical {
+		return physicalDamage(pAtk, pRecive, atk, def)
+	}
+	return specialDamage(pAtk, pRecive, atk, def)
+}
+
+func physicalDamage(pAtk *BattlePokemon, pRecive *BattlePokemon, atkStage int, defStage int) int {
+	dmg := float32(staged(pAtk.Atk, atkStage)) - float32(staged(pRecive.Def, defStage))
+	if dmg < 0 {
+		dmg = 0
+	}
+	return int(dmg)
+}
+
+func specialDamage(pAtk *BattlePokemon, pRecive *BattlePokemon, atkStage int, defStage int) int {
+	var best float32 = 0.0
+	for _, pAtkTypes := range pAtk.Types {
+		if def := typeDefense(pRecive, pAtkTypes); best < def {
+			best = def
+		}
+	}
+	dmg := float32(staged(pAtk.SpAtk, atkStage))*best - float32(staged(pRecive.SpDef, defStage))
+	if dmg < 0 {
+		dmg = 0
+	}
+	return int(dmg)
+}
+
+// typeDefense is the multiplier of attacks of type t against p
+func typeDefense(p *BattlePokemon, t string) float32 {
+	d := p.TypeDefense
+	switch t {
+	case "Normal":
+		return d.Normal
+	case "Fire":
+		return d.Fire
+	case "Water":
+		return d.Water
+	case "Electric":
+		return d.Electric
+	case "Grass":
+		return d.Grass
+	case "Ice":
+		return d.Ice
+	case "Fighting":
+		return d.Fighting
+	case "Poison":
+		return d.Poison
+	case "Ground":
+		return d.Ground
+	case "Flying":
+		return d.Flying
+	case "Psychic":
+		return d.Psychic
+	case "Bug":
+		return d.Bug
+	case "Rock":
+		return d.Rock
+	case "Ghost":
+		return d.Ghost
+	case "Dragon":
+		return d.Dragon
+	case "Dark":
+		return d.Dark
+	case "Steel":
+		return d.Steel
+	case "Fairy":
+		return d.Fairy
+	}
+	return 0
+}
+
+// ExpectedDamage is the average damage pAtk does to pRecive in one hit
+func ExpectedDamage(pAtk *BattlePokemon, pRecive *BattlePokemon) float64 {
+	return float64(damage(pAtk, pRecive, true, false)+damage(pAtk, pRecive, false, false)) / 2
+}
+
+func (s BattleState) clone() BattleState {
+	next := s
+	next.Players = append([]string(nil), s.Players...)
+	next.Teams = make(map[string][]BattlePokemon)
+	for name, team := range s.Teams {
+		next.Teams[name] = append([]BattlePokemon(nil), team...)
+	}
+	next.Active = make(map[string]int)
+	for name, i := range s.Active {
+		next.Active[name] = i
+	}
+	next.ForcedSwitch = make(map[string]bool)
+	for name, forced := range s.ForcedSwitch {
+		next.ForcedSwitch[name] = forced
+	}
+	if s.Hazards != nil {
+		next.Hazards = make(map[string]map[string]int)
+		for name, layers := range s.Hazards {
+			next.Hazards[name] = make(map[string]int)
+			for hazard, n := range layers {
+				next.Hazards[name][hazard] = n
+			}
+		}
+	}
+	if s.Slots != nil {
+		next.Sides = make(map[string]string)
+		for name, side := range s.Sides {
+			next.Sides[name] = side
+		}
+		next.Slots = make(map[string][]int)
+		for name, slots := range s.Slots {
+			next.Slots[name] = append([]int(nil), slots...)
+		}
+		next.Pending = make(map[string][]Action)
+		for name, actions := range s.Pending {
+			next.Pending[name] = append([]Action(nil), actions...)
+		}
+	}
+	return next
+}
+
+// ActivePokemon is the pokemon player has in the field, nil while it has to
+// be replaced after fainting. In simultaneous formats it is the pokemon in
+// the first slot.
+func (s *BattleState) ActivePokemon(player string) *BattlePokemon {
+	if s.Slots != nil {
+		return s.SlotPokemon(player, 1)
+	}
+	team, ok := s.Teams[player]
+	if !ok || s.ForcedSwitch[player] {
+		return nil
+	}
+	return &team[s.Active[player]]
+}
+
+// Waiting lists the players the battle waits for
+func (s *BattleState) Waiting() []string {
+	if s.Winner != "" {
+		return nil
+	}
+	if s.Slots == nil {
+		return []string{s.CurrentTurn}
+	}
+	var waiting []string
+	for _, name := range s.Players {
+		if s.ForcedSwitch[name] {
+			waiting = append(waiting, name)
+		}
+	}
+	if len(waiting) > 0 {
+		return waiting
+	}
+	for _, name := range s.Players {
+		if len(s.MissingSlots(name)) > 0 {
+			waiting = append(waiting, name)
+		}
+	}
+	return waiting
+}
+
+// Remaining lists the pokemons of player that have not fainted
+func (s *BattleState) Remaining(player string) []BattlePokemon {
+	var alive []BattlePokemon
+	for _, p := range s.Teams[player] {
+		if p.Hp > 0 {
+			alive = append(alive, p)
+		}
+	}
+	return alive
+}
+
+func (s *BattleState) Opponent(player string) string {
+	for _, name := range s.Players {
+		if name != player {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *BattleState) pokemonIndex(player string, id string) int {
+	for i, p := range s.Teams[player] {
+		if p.ID == id {
+			return i
+		}
+	}
+	return -1
+}
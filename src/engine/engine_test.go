@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testClock = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// testPokemon hits for 20 HP, 30 on a critical hit, both physical and special
+func testPokemon(id string, hp int, speed int) BattlePokemon {
+	return BattlePokemon{Name: "Mon" + id, ID: id, Level: 5, Types: []string{"Normal"}, Hp: hp,
+		Atk: 30, Def: 10, SpAtk: 30, SpDef: 10, Speed: speed, TypeDefense: TypeDef{Normal: 1}}
+}
+
+func newTestEngine() *Engine {
+	return NewEngine(42, func() time.Time { return testClock })
+}
+
+func kinds(events []Event) []EventKind {
+	var list []EventKind
+	for _, event := range events {
+		list = append(list, event.Kind)
+	}
+	return list
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		teams   map[string][]BattlePokemon
+		actions []Action // played in order, all but the last one must succeed
+		err     error    // error of the last action
+		events  []EventKind
+		turn    string
+		winner  string
+		check   func(t *testing.T, state BattleState, events []Event)
+	}{
+		{
+			name:    "attack",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionAttack}},
+			events:  []EventKind{EventHit, EventTurn},
+			turn:    "gary",
+			check: func(t *testing.T, state BattleState, events []Event) {
+				hit := events[0]
+				if hit.Damage != 20 && hit.Damage != 30 {
+					t.Errorf("damage %d, want 20 or 30 on a critical hit", hit.Damage)
+				}
+				if hp := state.ActivePokemon("gary").Hp; hp != 100-hit.Damage || hit.Hp != hp {
+					t.Errorf("gary has %d HP, the hit says %d, want %d", hp, hit.Hp, 100-hit.Damage)
+				}
+				if state.Turn != 1 {
+					t.Errorf("turn %d, want 1", state.Turn)
+				}
+			},
+		},
+		{
+			name:    "switch uses the turn",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50), testPokemon("a2", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionChange, PokemonID: "a2"}},
+			events:  []EventKind{EventSwitch, EventTurn},
+			turn:    "gary",
+			check: func(t *testing.T, state BattleState, events []Event) {
+				if p := state.ActivePokemon("ash"); p.ID != "a2" {
+					t.Errorf("ash has %s in the field, want a2", p.ID)
+				}
+			},
+		},
+		{
+			name:    "fainted pokemon must be replaced",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 15, 10), testPokemon("g2", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionAttack}},
+			events:  []EventKind{EventHit, EventFaint, EventTurn},
+			turn:    "gary",
+			check: func(t *testing.T, state BattleState, events []Event) {
+				if !state.ForcedSwitch["gary"] || state.ActivePokemon("gary") != nil {
+					t.Errorf("gary should have to replace the fainted pokemon")
+				}
+			},
+		},
+		{
+			name:  "attacking before replacing a fainted pokemon",
+			teams: map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 15, 10), testPokemon("g2", 100, 10)}},
+			actions: []Action{
+				{Player: "ash", Kind: ActionAttack},
+				{Player: "gary", Kind: ActionAttack},
+			},
+			err:  ErrMustSwitch,
+			turn: "gary",
+		},
+		{
+			name:  "replacing a fainted pokemon keeps the turn",
+			teams: map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 15, 10), testPokemon("g2", 100, 10)}},
+			actions: []Action{
+				{Player: "ash", Kind: ActionAttack},
+				{Player: "gary", Kind: ActionChange, PokemonID: "g2"},
+			},
+			events: []EventKind{EventSendOut},
+			turn:   "gary",
+		},
+		{
+			name:    "win",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 15, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionAttack}},
+			events:  []EventKind{EventHit, EventFaint, EventWin},
+			turn:    "gary",
+			winner:  "ash",
+		},
+		{
+			name:  "no action after the win",
+			teams: map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 15, 10)}},
+			actions: []Action{
+				{Player: "ash", Kind: ActionAttack},
+				{Player: "gary", Kind: ActionAttack},
+			},
+			err:    ErrBattleOver,
+			turn:   "gary",
+			winner: "ash",
+		},
+		{
+			name:    "the faster pokemon moves first",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 10)}, "gary": {testPokemon("g1", 100, 50)}},
+			actions: []Action{{Player: "ash", Kind: ActionAttack}},
+			err:     ErrNotYourTurn,
+			turn:    "gary",
+		},
+		{
+			name:  "turns alternate",
+			teams: map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{
+				{Player: "ash", Kind: ActionAttack},
+				{Player: "ash", Kind: ActionAttack},
+			},
+			err:  ErrNotYourTurn,
+			turn: "gary",
+		},
+		{
+			name:    "switch to an unknown pokemon",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionChange, PokemonID: "zz"}},
+			err:     ErrInvalidPokemon,
+			turn:    "ash",
+		},
+		{
+			name:    "switch to the pokemon in the field",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50), testPokemon("a2", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionChange, PokemonID: "a1"}},
+			err:     ErrInvalidPokemon,
+			turn:    "ash",
+		},
+		{
+			name:    "unknown move",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: ActionMove, Move: "splashy"}},
+			err:     ErrInvalidAction,
+			turn:    "ash",
+		},
+		{
+			name:    "unknown action",
+			teams:   map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}},
+			actions: []Action{{Player: "ash", Kind: "dance"}},
+			err:     ErrInvalidAction,
+			turn:    "ash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine()
+			state, _ := e.Start(tt.teams, "ash")
+			var events []Event
+			var err error
+			for i, action := range tt.actions {
+				var next BattleState
+				next, events, err = e.Apply(state, action)
+				if i < len(tt.actions)-1 && err != nil {
+					t.Fatalf("action %d %v: %v", i, action, err)
+				}
+				if err == nil {
+					state = next
+				}
+			}
+			if err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if got := kinds(events); !reflect.DeepEqual(got, tt.events) {
+				t.Errorf("events %v, want %v", got, tt.events)
+			}
+			for _, event := range events {
+				if !event.At.Equal(testClock) {
+					t.Errorf("event %s at %v, want the clock %v", event.Kind, event.At, testClock)
+				}
+			}
+			if state.CurrentTurn != tt.turn {
+				t.Errorf("turn of %q, want %q", state.CurrentTurn, tt.turn)
+			}
+			if state.Winner != tt.winner {
+				t.Errorf("winner %q, want %q", state.Winner, tt.winner)
+			}
+			if tt.check != nil {
+				tt.check(t, state, events)
+			}
+		})
+	}
+}
+
+func TestStartTurnOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		ash, gary  int // speeds
+		tieBreaker string
+		want       string
+	}{
+		{"ash is faster", 50, 10, "gary", "ash"},
+		{"gary is faster", 10, 50, "ash", "gary"},
+		{"tie", 30, 30, "gary", "gary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			teams := map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, tt.ash)}, "gary": {testPokemon("g1", 100, tt.gary)}}
+			state, events := newTestEngine().Start(teams, tt.tieBreaker)
+			if state.CurrentTurn != tt.want {
+				t.Errorf("%q moves first, want %q", state.CurrentTurn, tt.want)
+			}
+			if len(events) == 0 || events[0].Kind != EventStart || events[0].Player != tt.want {
+				t.Errorf("events %v, want a start event for %q first", events, tt.want)
+			}
+			if !state.StartedAt.Equal(testClock) {
+				t.Errorf("started at %v, want the clock %v", state.StartedAt, testClock)
+			}
+		})
+	}
+}
+
+func TestApplySameSeedSameBattle(t *testing.T) {
+	teams := map[string][]BattlePokemon{
+		"ash":  {testPokemon("a1", 100, 50), testPokemon("a2", 100, 40)},
+		"gary": {testPokemon("g1", 100, 45), testPokemon("g2", 100, 35)},
+	}
+	play := func() (BattleState, []Event) {
+		e := newTestEngine()
+		state, events := e.Start(teams, "ash")
+		for state.Winner == "" && state.Turn < 100 {
+			action := Action{Player: state.CurrentTurn, Kind: ActionAttack}
+			if state.ForcedSwitch[state.CurrentTurn] {
+				action = Action{Player: state.CurrentTurn, Kind: ActionChange, PokemonID: state.Remaining(state.CurrentTurn)[0].ID}
+			}
+			next, more, err := e.Apply(state, action)
+			if err != nil {
+				t.Fatalf("turn %d: %v", state.Turn, err)
+			}
+			state, events = next, append(events, more...)
+		}
+		return state, events
+	}
+	first, firstEvents := play()
+	second, secondEvents := play()
+	if first.Winner == "" {
+		t.Fatalf("no winner after %d turns", first.Turn)
+	}
+	if first.Winner != second.Winner || first.Turn != second.Turn || !reflect.DeepEqual(firstEvents, secondEvents) {
+		t.Errorf("two battles with the same seed differ: %s won in %d turns, then %s in %d", first.Winner, first.Turn, second.Winner, second.Turn)
+	}
+}
+
+func TestApplyLeavesStateUntouched(t *testing.T) {
+	e := newTestEngine()
+	teams := map[string][]BattlePokemon{"ash": {testPokemon("a1", 100, 50)}, "gary": {testPokemon("g1", 100, 10)}}
+	state, _ := e.Start(teams, "ash")
+	if _, _, err := e.Apply(state, Action{Player: "ash", Kind: ActionAttack}); err != nil {
+		t.Fatal(err)
+	}
+	if hp := state.ActivePokemon("gary").Hp; hp != 100 || state.CurrentTurn != "ash" || state.Turn != 0 {
+		t.Errorf("the given state changed: gary has %d HP, turn %d of %s", hp, state.Turn, state.CurrentTurn)
+	}
+}
@@ -0,0 +1,150 @@
+package engine
+
+import "strings"
+
+// The field has a weather and a terrain, both set by moves ("@move
+// raindance", see moves.go) or abilities (Drizzle, see abilities.go) and
+// both lasting FieldRounds rounds, a round being one action of every
+// player. While they last:
+//
+//	rain       water attacks x1.5, fire attacks x0.5, water pokemons twice as fast
+//	sun        fire attacks x1.5, water attacks x0.5, fire and grass pokemons twice as fast
+//	sandstorm  1/16 of max HP damage every round but to rock, ground and steel pokemons, ground pokemons twice as fast
+//	hail       1/16 of max HP damage every round but to ice pokemons, ice pokemons twice as fast
+//	electric, grassy and psychic terrains  attacks of their type x1.3
+//	grassy terrain  heals 1/16 of max HP every round
+//	misty terrain   dragon attacks x0.5
+//
+// The type of an attack is the type of the attacker that does the most
+// against the target. The field damage and healing happen at the end of the
+// round in doubles, team battles and free-for-all, and in singles to the
+// pokemon of the player whose turn starts. Being faster only matters where
+// the pokemons act in speed order, in doubles, team battles and
+// free-for-all: singles players take turns, and the weather does not change
+// who moves first.
+type Field struct {
+	Weather      string `json:"Weather,omitempty"`
+	WeatherUntil int    `json:"WeatherUntil,omitempty"` // Turn at which the weather ends
+	Terrain      string `json:"Terrain,omitempty"`
+	TerrainUntil int    `json:"TerrainUntil,omitempty"`
+}
+
+const FieldRounds = 5
+
+// fieldEffects are the weathers and terrains a move can set
+var fieldEffects = map[string]bool{"rain": true, "sun": true, "sandstorm": true, "hail": true,
+	"electric terrain": true, "grassy terrain": true, "psychic terrain": true, "misty terrain": true}
+
+// IsTerrain tells if a field effect is a terrain rather than a weather
+func IsTerrain(effect string) bool {
+	return strings.HasSuffix(effect, " terrain")
+}
+
+// setField starts the effect of a field move for FieldRounds rounds. In
+// singles every action is a turn, so a round is two turns.
+func (e *Engine) setField(state *BattleState, player string, pokemon string, slot int, effect string) Event {
+	until := state.Turn + FieldRounds
+	if state.Slots == nil {
+		until = state.Turn + 2*FieldRounds
+	}
+	if IsTerrain(effect) {
+		state.Field.Terrain, state.Field.TerrainUntil = effect, until
+	} else {
+		state.Field.Weather, state.Field.WeatherUntil = effect, until
+	}
+	return Event{Kind: EventField, Player: player, Pokemon: pokemon, Slot: slot, Effect: effect, At: e.clock()}
+}
+
+// expireField ends the weather and the terrain whose time is up
+func (e *Engine) expireField(state *BattleState) []Event {
+	var events []Event
+	if state.Field.Weather != "" && state.Turn >= state.Field.WeatherUntil {
+		events = append(events, Event{Kind: EventFieldEnd, Effect: state.Field.Weather, At: e.clock()})
+		state.Field.Weather, state.Field.WeatherUntil = "", 0
+	}
+	if state.Field.Terrain != "" && state.Turn >= state.Field.TerrainUntil {
+		events = append(events, Event{Kind: EventFieldEnd, Effect: state.Field.Terrain, At: e.clock()})
+		state.Field.Terrain, state.Field.TerrainUntil = "", 0
+	}
+	return events
+}
+
+// residual applies the end of round damage or healing of the field to a
+// pokemon in the field
+func (e *Engine) residual(state *BattleState, player string, p *BattlePokemon, slot int) []Event {
+	var events []Event
+	amount := p.MaxHp / 16
+	if amount < 1 {
+		amount = 1
+	}
+	if hurt := state.Field.Weather; (hurt == "sandstorm" && !hasType(p, "Rock", "Ground", "Steel")) || (hurt == "hail" && !hasType(p, "Ice")) {
+		p.Hp -= amount
+		if p.Hp < 0 {
+			p.Hp = 0
+		}
+		events = append(events, Event{Kind: EventResidual, Player: player, Pokemon: p.Name, Slot: slot, Effect: hurt,
+			Damage: amount, Hp: p.Hp, At: e.clock()})
+	}
+	if state.Field.Terrain == "grassy terrain" && p.Hp > 0 && p.Hp < p.MaxHp {
+		if p.Hp+amount > p.MaxHp {
+			amount = p.MaxHp - p.Hp
+		}
+		p.Hp += amount
+		events = append(events, Event{Kind: EventHeal, Player: player, Pokemon: p.Name, Slot: slot, Effect: state.Field.Terrain,
+			Damage: amount, Hp: p.Hp, At: e.clock()})
+	}
+	return events
+}
+
+// fieldDamage changes the damage of an attack with the field
+func fieldDamage(state *BattleState, pAtk *BattlePokemon, pRecive *BattlePokemon, dmg int) int {
+	if state.Field.Weather == "" && state.Field.Terrain == "" {
+		return dmg
+	}
+	modifier := 1.0
+	switch attackType(pAtk, pRecive) + "/" + state.Field.Weather {
+	case "Water/rain", "Fire/sun":
+		modifier *= 1.5
+	case "Fire/rain", "Water/sun":
+		modifier *= 0.5
+	}
+	switch attackType(pAtk, pRecive) + "/" + state.Field.Terrain {
+	case "Electric/electric terrain", "Grass/grassy terrain", "Psychic/psychic terrain":
+		modifier *= 1.3
+	case "Dragon/misty terrain":
+		modifier *= 0.5
+	}
+	return int(float64(dmg) * modifier)
+}
+
+// attackType is the type of pAtk that does the most against pRecive
+func attackType(pAtk *BattlePokemon, pRecive *BattlePokemon) string {
+	best, bestDefense := "", float32(-1)
+	for _, t := range pAtk.Types {
+		if def := typeDefense(pRecive, t); def > bestDefense {
+			best, bestDefense = t, def
+		}
+	}
+	return best
+}
+
+// speed is the speed of a pokemon with its speed stage and the weather, for
+// the order of the actions of simultaneous formats
+func (s *BattleState) speed(p *BattlePokemon) int {
+	fast := map[string][]string{"rain": {"Water"}, "sun": {"Fire", "Grass"}, "sandstorm": {"Ground"}, "hail": {"Ice"}}
+	if hasType(p, fast[s.Field.Weather]...) {
+		return staged(p.Speed, p.Stages.Speed) * 2
+	}
+	return staged(p.Speed, p.Stages.Speed)
+}
+
+func hasType(p *BattlePokemon, types ...string) bool {
+	for _, t := range p.Types {
+		for _, other := range types {
+			if t == other {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package engine
+
+// Medicines are the items of the bag a player can use in a battle with
+// "@use item [pokemonID]", on the pokemon of the acting slot or on another
+// one of their team. Using one costs the action of the slot. The server
+// takes the item out of the bag when the engine reports an EventUse.
+type Medicine struct {
+	Name   string
+	Heal   int // HP a potion heals
+	Revive int // percent of max HP a revived pokemon comes back with
+}
+
+var Medicines = map[string]Medicine{
+	"potion":      {Name: "Potion", Heal: 20},
+	"superpotion": {Name: "Super Potion", Heal: 60},
+	"hyperpotion": {Name: "Hyper Potion", Heal: 120},
+	"revive":      {Name: "Revive", Revive: 50},
+	"maxrevive":   {Name: "Max Revive", Revive: 100},
+}
+
+// FindMedicine finds a medicine whatever its case and spaces, nil for the
+// items that cannot be used in a battle
+func FindMedicine(name string) *Medicine {
+	if m, ok := Medicines[EffectKey(name)]; ok {
+		return &m
+	}
+	return nil
+}
+
+// useItem plays a medicine of the bag in a battle, on the pokemon of the
+// acting slot or on the pokemon of the team whose ID the action names
+func (e *Engine) useItem(state *BattleState, action Action) ([]Event, error) {
+	item := FindMedicine(action.Item)
+	if item == nil {
+		return nil, ErrInvalidAction
+	}
+	p := state.ActivePokemon(action.Player)
+	if state.Slots != nil {
+		p = state.SlotPokemon(action.Player, action.Slot)
+	}
+	if action.PokemonID != "" {
+		i := state.pokemonIndex(action.Player, action.PokemonID)
+		if i < 0 {
+			return nil, ErrInvalidPokemon
+		}
+		p = &state.Teams[action.Player][i]
+	}
+	if p == nil {
+		return nil, ErrInvalidPokemon
+	}
+	before := p.Hp
+	switch {
+	case item.Revive > 0:
+		if p.Hp > 0 {
+			return nil, ErrNoEffect
+		}
+		if p.Hp = p.MaxHp * item.Revive / 100; p.Hp < 1 {
+			p.Hp = 1
+		}
+	default:
+		if p.Hp <= 0 || p.Hp >= p.MaxHp {
+			return nil, ErrNoEffect
+		}
+		if p.Hp += item.Heal; p.Hp > p.MaxHp {
+			p.Hp = p.MaxHp
+		}
+	}
+	return []Event{{Kind: EventUse, Player: action.Player, Slot: action.Slot, Pokemon: p.Name, Effect: item.Name,
+		Damage: p.Hp - before, Hp: p.Hp, At: e.clock()}}, nil
+}
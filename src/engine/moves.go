@@ -0,0 +1,352 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Moves are used with "@move name" (see ParseAction for the other formats)
+// and are described by data, the built-in moves below and the ones of the
+// -moves file. A damaging move hits like an attack, its Power being a
+// percentage of the damage of a plain @attack, then its secondary effects
+// happen in this order: recoil and drain (a percentage of the damage dealt),
+// stat changes and flinch. A status move sets the weather or the terrain,
+// protects the user, lays an entry hazard or changes stat stages.
+//
+// Protect blocks every attack aimed at the user until its next turn in
+// singles, for the rest of the round otherwise, and fails when used twice in
+// a row. A flinched pokemon loses its next action. Entry hazards stay on the
+// side of the opponents and hurt every pokemon they send in:
+//
+//	stealth rock  1/8 of max HP times the weakness to rock
+//	spikes        1/8, 1/6 then 1/4 of max HP with 1, 2 or 3 layers, flying pokemons are immune
+//
+// Like the damage of abilities and items, recoil and hazards leave at least
+// 1 HP.
+type (
+	Move struct {
+		Name     string       `json:"Name"`
+		Category string       `json:"Category"`           // "physical", "special" or "status"
+		Power    int          `json:"Power,omitempty"`    // percent of the damage of a plain attack
+		Accuracy int          `json:"Accuracy,omitempty"` // percent, the one of a plain attack when 0
+		Priority int          `json:"Priority,omitempty"` // moves first in the round when higher
+		Hits     [2]int       `json:"Hits,omitempty"`     // min and max number of hits, once when empty
+		Recoil   int          `json:"Recoil,omitempty"`   // percent of the damage dealt lost by the user
+		Drain    int          `json:"Drain,omitempty"`    // percent of the damage dealt healed to the user
+		Flinch   int          `json:"Flinch,omitempty"`   // percent chance that the target loses its next action
+		Stats    []StatChange `json:"Stats,omitempty"`
+		Protect  bool         `json:"Protect,omitempty"`
+		Hazard   string       `json:"Hazard,omitempty"` // "stealth rock" or "spikes"
+		Field    string       `json:"Field,omitempty"`  // weather or terrain, see field.go
+	}
+
+	StatChange struct {
+		Stat   string `json:"Stat"` // "attack", "evasion"... see Stages.stage
+		Change int    `json:"Change"`
+		Foes   bool   `json:"Foes,omitempty"`   // the target, or every opponent for a status move, instead of the user
+		Chance int    `json:"Chance,omitempty"` // percent, always when 0
+	}
+
+	// Volatile is the state of a pokemon that is lost when it leaves the field
+	Volatile struct {
+		LastMove  string
+		Protected bool
+		Flinched  bool
+	}
+)
+
+const (
+	Physical = "physical"
+	Special  = "special"
+	Status   = "status"
+)
+
+var moves = map[string]Move{
+	"raindance":       {Name: "raindance", Category: Status, Field: "rain"},
+	"sunnyday":        {Name: "sunnyday", Category: Status, Field: "sun"},
+	"sandstorm":       {Name: "sandstorm", Category: Status, Field: "sandstorm"},
+	"hail":            {Name: "hail", Category: Status, Field: "hail"},
+	"electricterrain": {Name: "electricterrain", Category: Status, Field: "electric terrain"},
+	"grassyterrain":   {Name: "grassyterrain", Category: Status, Field: "grassy terrain"},
+	"psychicterrain":  {Name: "psychicterrain", Category: Status, Field: "psychic terrain"},
+	"mistyterrain":    {Name: "mistyterrain", Category: Status, Field: "misty terrain"},
+	"doubleteam":      {Name: "doubleteam", Category: Status, Stats: []StatChange{{Stat: "evasion", Change: 1}}},
+	"minimize":        {Name: "minimize", Category: Status, Stats: []StatChange{{Stat: "evasion", Change: 2}}},
+	"focusenergy":     {Name: "focusenergy", Category: Status, Stats: []StatChange{{Stat: "critical hit ratio", Change: 2}}},
+	"swordsdance":     {Name: "swordsdance", Category: Status, Stats: []StatChange{{Stat: "attack", Change: 2}}},
+	"harden":          {Name: "harden", Category: Status, Stats: []StatChange{{Stat: "defense", Change: 1}}},
+	"agility":         {Name: "agility", Category: Status, Stats: []StatChange{{Stat: "speed", Change: 2}}},
+	"sandattack":      {Name: "sandattack", Category: Status, Stats: []StatChange{{Stat: "accuracy", Change: -1, Foes: true}}},
+	"smokescreen":     {Name: "smokescreen", Category: Status, Stats: []StatChange{{Stat: "accuracy", Change: -1, Foes: true}}},
+	"growl":           {Name: "growl", Category: Status, Stats: []StatChange{{Stat: "attack", Change: -1, Foes: true}}},
+	"protect":         {Name: "protect", Category: Status, Priority: 4, Protect: true},
+	"detect":          {Name: "detect", Category: Status, Priority: 4, Protect: true},
+	"stealthrock":     {Name: "stealthrock", Category: Status, Hazard: "stealth rock"},
+	"spikes":          {Name: "spikes", Category: Status, Hazard: "spikes"},
+	"doubleedge":      {Name: "doubleedge", Category: Physical, Power: 120, Accuracy: 100, Recoil: 33},
+	"bravebird":       {Name: "bravebird", Category: Physical, Power: 120, Accuracy: 100, Recoil: 33},
+	"gigadrain":       {Name: "gigadrain", Category: Special, Power: 75, Accuracy: 100, Drain: 50},
+	"drainpunch":      {Name: "drainpunch", Category: Physical, Power: 75, Accuracy: 100, Drain: 50},
+	"bulletseed":      {Name: "bulletseed", Category: Physical, Power: 25, Accuracy: 100, Hits: [2]int{2, 5}},
+	"doublekick":      {Name: "doublekick", Category: Physical, Power: 50, Accuracy: 100, Hits: [2]int{2, 2}},
+	"headbutt":        {Name: "headbutt", Category: Physical, Power: 70, Accuracy: 100, Flinch: 30},
+	"ironhead":        {Name: "ironhead", Category: Physical, Power: 80, Accuracy: 100, Flinch: 30},
+	"airslash":        {Name: "airslash", Category: Special, Power: 75, Accuracy: 95, Flinch: 30},
+	"quickattack":     {Name: "quickattack", Category: Physical, Power: 40, Accuracy: 100, Priority: 1},
+	"closecombat": {Name: "closecombat", Category: Physical, Power: 120, Accuracy: 100, Stats: []StatChange{
+		{Stat: "defense", Change: -1}, {Stat: "special defense", Change: -1}}},
+	"crunch":  {Name: "crunch", Category: Physical, Power: 80, Accuracy: 100, Stats: []StatChange{{Stat: "defense", Change: -1, Foes: true, Chance: 20}}},
+	"psychic": {Name: "psychic", Category: Special, Power: 90, Accuracy: 100, Stats: []StatChange{{Stat: "special defense", Change: -1, Foes: true, Chance: 10}}},
+}
+
+// hazardLayers is how many times each entry hazard can be laid on a side
+var hazardLayers = map[string]int{"stealth rock": 1, "spikes": 3}
+
+// LoadMoves adds the moves of a file to the built-in ones, a move with the
+// name of a built-in one replaces it
+func LoadMoves(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var list []Move
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, move := range list {
+		move.Name = EffectKey(move.Name)
+		switch {
+		case move.Name == "":
+			return fmt.Errorf("a move needs a name")
+		case move.Category != Physical && move.Category != Special && move.Category != Status:
+			return fmt.Errorf("move %q: the category must be physical, special or status", move.Name)
+		case move.Hits[0] > move.Hits[1]:
+			return fmt.Errorf("move %q: the min number of hits is above the max", move.Name)
+		case move.Hazard != "" && hazardLayers[move.Hazard] == 0:
+			return fmt.Errorf("move %q: unknown hazard %q", move.Name, move.Hazard)
+		case move.Field != "" && !fieldEffects[move.Field]:
+			return fmt.Errorf("move %q: unknown weather or terrain %q", move.Name, move.Field)
+		}
+		for _, change := range move.Stats {
+			if new(Stages).stage(change.Stat) == nil {
+				return fmt.Errorf("move %q: unknown stat %q", move.Name, change.Stat)
+			}
+		}
+		moves[move.Name] = move
+	}
+	return nil
+}
+
+func (m Move) damaging() bool {
+	return m.Category != Status
+}
+
+// findMove finds a move whatever its case and punctuation
+func findMove(name string) (Move, bool) {
+	move, ok := moves[EffectKey(name)]
+	return move, ok
+}
+
+// IsMove tells if a name is a known move
+func IsMove(name string) bool {
+	_, ok := findMove(name)
+	return ok
+}
+
+// MoveNames lists every move, for help messages
+func MoveNames() string {
+	var names []string
+	for name := range moves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// leaveField forgets the stages and the volatile state of a pokemon that
+// goes back to the bench
+func leaveField(p *BattlePokemon) {
+	p.Stages = Stages{}
+	p.Volatile = Volatile{}
+}
+
+// useMove plays a status move of a pokemon in the field
+func (e *Engine) useMove(state *BattleState, self fighter, move Move) []Event {
+	switch {
+	case move.Field != "":
+		return []Event{e.setField(state, self.player, self.p.Name, self.slot, move.Field)}
+	case move.Protect:
+		if last, ok := findMove(self.p.Volatile.LastMove); ok && last.Protect {
+			return []Event{e.fail(self, move)}
+		}
+		self.p.Volatile.Protected = true
+		return []Event{{Kind: EventProtect, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Move: move.Name, At: e.clock()}}
+	case move.Hazard != "":
+		return e.layHazard(state, self, move)
+	}
+	var events []Event
+	for _, change := range move.Stats {
+		if change.Chance > 0 && e.rng.Intn(100) >= change.Chance {
+			continue
+		}
+		if !change.Foes {
+			events = append(events, e.raise(self, self, change.Stat, change.Change, move.Name))
+			continue
+		}
+		for _, foe := range state.foes(self.player) {
+			events = append(events, e.raise(self, foe, change.Stat, change.Change, move.Name))
+		}
+	}
+	return events
+}
+
+func (e *Engine) fail(self fighter, move Move) Event {
+	return Event{Kind: EventFail, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, Move: move.Name, At: e.clock()}
+}
+
+// layHazard adds a layer of an entry hazard on the side of every opponent,
+// the move fails when none can take one more
+func (e *Engine) layHazard(state *BattleState, self fighter, move Move) []Event {
+	opponents := state.Opponents(self.player)
+	if state.Slots == nil {
+		opponents = []string{state.Opponent(self.player)}
+	}
+	var events []Event
+	for _, name := range opponents {
+		if len(state.Remaining(name)) == 0 || state.Hazards[name][move.Hazard] >= hazardLayers[move.Hazard] {
+			continue
+		}
+		if state.Hazards == nil {
+			state.Hazards = make(map[string]map[string]int)
+		}
+		if state.Hazards[name] == nil {
+			state.Hazards[name] = make(map[string]int)
+		}
+		state.Hazards[name][move.Hazard]++
+		events = append(events, Event{Kind: EventHazard, Player: self.player, Pokemon: self.p.Name, Slot: self.slot,
+			Move: move.Name, Effect: move.Hazard, Target: name, At: e.clock()})
+	}
+	if len(events) == 0 {
+		return []Event{e.fail(self, move)}
+	}
+	return events
+}
+
+// hazards hurts a pokemon sent in on a side with entry hazards
+func (e *Engine) hazards(state *BattleState, self fighter) []Event {
+	var events []Event
+	layers := state.Hazards[self.player]
+	if layers["stealth rock"] > 0 {
+		weakness := typeDefense(self.p, "Rock")
+		if weakness == 0 { // no type chart, nothing is immune to rock
+			weakness = 1
+		}
+		events = append(events, e.hurt(self, int(float32(self.p.MaxHp)/8*weakness), "stealth rock")...)
+	}
+	if n := layers["spikes"]; n > 0 && !hasType(self.p, "Flying") {
+		events = append(events, e.hurt(self, self.p.MaxHp/[]int{8, 6, 4}[n-1], "spikes")...)
+	}
+	return events
+}
+
+// strike lands an attack of attacker on target: a damaging move, or a plain
+// attack (move is nil) randomly physical or special. It does nothing when
+// the target is protected or when it misses. Spread attacks do 3/4 of the
+// damage.
+func (e *Engine) strike(state *BattleState, attacker fighter, target fighter, spread bool, move *Move) []Event {
+	if target.p.Volatile.Protected {
+		return []Event{{Kind: EventProtect, Player: target.player, Pokemon: target.p.Name, Slot: target.slot,
+			Target: attacker.player, TargetPokemon: attacker.p.Name, TargetSlot: attacker.slot, At: e.clock()}}
+	}
+	h := &hit{attacker: attacker, target: target, typ: attackType(attacker.p, target.p)}
+	var physical bool
+	if move == nil {
+		physical = e.rng.Intn(2) == 0
+	} else {
+		physical = move.Category == Physical
+	}
+	accuracy := moveAccuracy[physical]
+	if move != nil && move.Accuracy > 0 {
+		accuracy = float64(move.Accuracy)
+	}
+	if !e.Classic && !e.hits(state, h, accuracy) {
+		return []Event{{Kind: EventMiss, Player: attacker.player, Pokemon: attacker.p.Name, Slot: attacker.slot,
+			Target: target.player, TargetPokemon: target.p.Name, TargetSlot: target.slot, Hp: target.p.Hp, At: e.clock()}}
+	}
+	if move == nil {
+		return e.blow(state, h, physical, spread, 100)
+	}
+
+	hits := 1
+	if move.Hits[1] > 0 {
+		hits = move.Hits[0] + e.rng.Intn(move.Hits[1]-move.Hits[0]+1)
+	}
+	var events []Event
+	dealt := 0
+	for i := 0; i < hits && target.p.Hp > 0; i++ {
+		events = append(events, e.blow(state, h, physical, spread, move.Power)...)
+		dealt += h.dmg
+	}
+	return append(events, e.secondary(state, h, *move, dealt)...)
+}
+
+// blow deals one hit of an attack that landed, power being a percentage of
+// the damage of a plain attack
+func (e *Engine) blow(state *BattleState, h *hit, physical bool, spread bool, power int) []Event {
+	attacker, target := h.attacker, h.target
+	critical := !e.Classic && e.critical(attacker.p)
+	h.dmg = fieldDamage(state, attacker.p, target.p, damage(attacker.p, target.p, physical, critical))
+	if power != 100 {
+		h.dmg = h.dmg * power / 100
+	}
+	if critical {
+		h.dmg = h.dmg * 3 / 2
+	}
+	if spread {
+		h.dmg = h.dmg * 3 / 4
+	}
+	notes := e.beforeDamage(state, h)
+	target.p.Hp -= h.dmg
+	if target.p.Hp < 0 {
+		target.p.Hp = 0
+	}
+	events := []Event{{Kind: EventHit, Player: attacker.player, Pokemon: attacker.p.Name, Slot: attacker.slot,
+		Target: target.player, TargetPokemon: target.p.Name, TargetSlot: target.slot, Damage: h.dmg, Hp: target.p.Hp, Critical: critical, At: e.clock()}}
+	events = append(events, notes...)
+	return append(events, e.afterDamage(state, h)...)
+}
+
+// secondary plays the effects of a damaging move after its hits, dealt
+// being the damage of all of them
+func (e *Engine) secondary(state *BattleState, h *hit, move Move, dealt int) []Event {
+	var events []Event
+	if move.Recoil > 0 && dealt > 0 {
+		events = append(events, e.hurt(h.attacker, dealt*move.Recoil/100, "recoil")...)
+	}
+	if move.Drain > 0 && dealt > 0 {
+		events = append(events, e.heal(h.attacker, dealt*move.Drain/100, "drain")...)
+	}
+	for _, change := range move.Stats {
+		target := h.attacker
+		if change.Foes {
+			target = h.target
+		}
+		if target.p.Hp <= 0 || change.Chance > 0 && e.rng.Intn(100) >= change.Chance {
+			continue
+		}
+		events = append(events, e.raise(h.attacker, target, change.Stat, change.Change, move.Name))
+	}
+	if move.Flinch > 0 && h.target.p.Hp > 0 && e.rng.Intn(100) < move.Flinch {
+		h.target.p.Volatile.Flinched = true
+	}
+	return events
+}
+
+// flinch tells a flinched pokemon lost its action
+func (e *Engine) flinch(self fighter) Event {
+	self.p.Volatile.Flinched = false
+	return Event{Kind: EventFlinch, Player: self.player, Pokemon: self.p.Name, Slot: self.slot, At: e.clock()}
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMoves(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		err  string // part of the error, none when empty
+	}{
+		{"valid", `[{"Name": "Test Dance", "Category": "status", "Stats": [{"Stat": "critical hit ratio", "Change": 1}], "Field": "rain"}]`, ""},
+		{"unknown stat", `[{"Name": "testdance", "Category": "status", "Stats": [{"Stat": "luck", "Change": 1}]}]`, `unknown stat "luck"`},
+		{"unknown field", `[{"Name": "testdance", "Category": "status", "Field": "fog"}]`, `unknown weather or terrain "fog"`},
+		{"unknown hazard", `[{"Name": "testdance", "Category": "status", "Hazard": "lava"}]`, `unknown hazard "lava"`},
+		{"unknown category", `[{"Name": "testdance", "Category": "magic"}]`, "the category must be"},
+		{"no name", `[{"Category": "status"}]`, "a move needs a name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer delete(moves, "testdance")
+			file := filepath.Join(t.TempDir(), "moves.json")
+			if err := ioutil.WriteFile(file, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := LoadMoves(file)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			case tt.err == "" && !IsMove("Test Dance"):
+				t.Errorf("the move was not added")
+			}
+		})
+	}
+}
+
+func TestStageOfUnknownStat(t *testing.T) {
+	var stages Stages
+	if stages.stage("luck") != nil {
+		t.Errorf("an unknown stat has a stage")
+	}
+	user := fighter{"ash", 0, &BattlePokemon{Name: "Pikachu"}}
+	event := newTestEngine().raise(user, user, "luck", 1, "testdance")
+	if event.Kind != EventFail || user.p.Stages != (Stages{}) {
+		t.Errorf("raising an unknown stat gave %v, want the move to fail", event)
+	}
+}
@@ -0,0 +1,49 @@
+package engine
+
+// A BattlePokemon is a pokemon in a battle, with the stats it fights with.
+// The server makes them from the pokemons of the player store or from the
+// pokedex.
+type (
+	BattlePokemon struct {
+		Name        string `json:"Name"`
+		ID          string
+		Level       int
+		Exp         int
+		Types       []string `json:"types"`
+		Hp          int      `json:"HP"`
+		MaxHp       int      `json:"MaxHP,omitempty"` // HP at the start of the battle, set by the engine
+		Atk         int      `json:"ATK"`
+		Def         int      `json:"DEF"`
+		SpAtk       int      `json:"Sp.Atk"` // as in the pokedex and the replays, the player store says S.Atk
+		SpDef       int      `json:"Sp.Def"`
+		Speed       int      `json:"Speed"`
+		TypeDefense TypeDef  `json:"Type-Defenses"`
+		Ability     string   `json:"Ability,omitempty"`
+		Item        string   `json:"Item,omitempty"`
+		Stages      Stages   `json:"-"` // stat stages in the field, see stages.go
+		Volatile    Volatile `json:"-"` // protection, flinch and last move in the field, see moves.go
+	}
+
+	// TypeDef is the damage multiplier of every type of attack against a
+	// species
+	TypeDef struct {
+		Normal   float32 `json:"Normal"`
+		Fire     float32 `json:"Fire"`
+		Water    float32 `json:"Water"`
+		Electric float32 `json:"Electric"`
+		Grass    float32 `json:"Grass"`
+		Ice      float32 `json:"Ice"`
+		Fighting float32 `json:"Fighting"`
+		Poison   float32 `json:"Poison"`
+		Ground   float32 `json:"Ground"`
+		Flying   float32 `json:"Flying"`
+		Psychic  float32 `json:"Psychic"`
+		Bug      float32 `json:"Bug"`
+		Rock     float32 `json:"Rock"`
+		Ghost    float32 `json:"Ghost"`
+		Dragon   float32 `json:"Dragon"`
+		Dark     float32 `json:"Dark"`
+		Steel    float32 `json:"Steel"`
+		Fairy    float32 `json:"Fairy"`
+	}
+)
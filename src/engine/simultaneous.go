@@ -0,0 +1,498 @@
+package engine
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Doubles, team battles and free-for-all are played with simultaneous turns:
+// every player chooses an action for each of their slots, and once all the
+// actions are in the turn is played, switches first, then attacks from the
+// fastest pokemon to the slowest.
+//
+//   - doubles: two players with two slots each, an attack targets the
+//     opponent's slot 1 or 2
+//   - team: two sides of two players with one slot each, an attack targets a
+//     player of the other side
+//   - ffa: three or four players with one slot each, everybody against
+//     everybody
+//
+// An attack whose target fainted in the meantime hits another opponent, a
+// spread attack ("all") hits every opponent for 3/4 of the damage. Fainted
+// pokemons are replaced between turns while the player has pokemons left on
+// the bench. A player without pokemons is out, the last side standing wins.
+
+const DoublesSlots = 2
+
+type position struct {
+	player string
+	slot   int
+}
+
+// StartDoubles sends out the first two pokemons of every team
+func (e *Engine) StartDoubles(teams map[string][]BattlePokemon) (BattleState, []Event) {
+	return e.startSimultaneous(Doubles, teams, nil, DoublesSlots)
+}
+
+// StartMulti starts a team battle or a free-for-all. sides gives the side
+// of every player, every player is on their own side when it is nil.
+func (e *Engine) StartMulti(format BattleFormat, teams map[string][]BattlePokemon, sides map[string]string) (BattleState, []Event) {
+	return e.startSimultaneous(format, teams, sides, 1)
+}
+
+func (e *Engine) startSimultaneous(format BattleFormat, teams map[string][]BattlePokemon, sides map[string]string, slots int) (BattleState, []Event) {
+	state := BattleState{
+		Format:       format,
+		Teams:        make(map[string][]BattlePokemon),
+		Active:       make(map[string]int),
+		ForcedSwitch: make(map[string]bool),
+		Sides:        make(map[string]string),
+		Slots:        make(map[string][]int),
+		Pending:      make(map[string][]Action),
+		StartedAt:    e.clock(),
+	}
+	for name, team := range teams {
+		state.Players = append(state.Players, name)
+		state.Teams[name] = withMaxHp(team)
+		state.Sides[name] = name
+		if side, ok := sides[name]; ok {
+			state.Sides[name] = side
+		}
+		for slot := 0; slot < slots; slot++ {
+			i := slot
+			if i >= len(team) {
+				i = -1
+			}
+			state.Slots[name] = append(state.Slots[name], i)
+		}
+		state.Pending[name] = make([]Action, slots)
+	}
+	sort.Strings(state.Players)
+	events := []Event{{Kind: EventStart, At: e.clock()}}
+	for _, name := range state.Players {
+		for slot := 1; slot <= slots; slot++ {
+			if p := state.SlotPokemon(name, slot); p != nil {
+				events = append(events, e.switchIn(&state, fighter{name, slot, p})...)
+			}
+		}
+	}
+	return state, append(events, Event{Kind: EventTurn, At: e.clock()})
+}
+
+func (e *Engine) applySimultaneous(state BattleState, action Action) (BattleState, []Event, error) {
+	if _, ok := state.Slots[action.Player]; !ok {
+		return state, nil, ErrNotYourTurn
+	}
+	next := state.clone()
+	if action.Kind == ActionForfeit {
+		if len(next.Remaining(action.Player)) == 0 {
+			return state, nil, ErrInvalidAction
+		}
+		return next, e.forfeit(&next, action.Player), nil
+	}
+	if len(state.ForcedSwitch) > 0 {
+		if !state.ForcedSwitch[action.Player] {
+			return state, nil, ErrNotYourTurn
+		}
+		if action.Kind != ActionChange {
+			return state, nil, ErrMustSwitch
+		}
+		return e.sendOut(&next, action)
+	}
+
+	if action.Slot == 0 && len(next.Slots[action.Player]) == 1 {
+		action.Slot = 1
+	}
+	if action.Slot < 1 || action.Slot > len(next.Slots[action.Player]) || next.SlotPokemon(action.Player, action.Slot) == nil {
+		return state, nil, ErrInvalidAction
+	}
+	switch action.Kind {
+	case ActionAttack:
+		if !next.validTarget(action.Player, action.Target) {
+			return state, nil, ErrInvalidAction
+		}
+	case ActionMove:
+		move, ok := findMove(action.Move)
+		if !ok || move.damaging() && !next.validTarget(action.Player, action.Target) || !move.damaging() && action.Target != "" {
+			return state, nil, ErrInvalidAction
+		}
+	case ActionItem:
+		probe := next.clone()
+		if _, err := e.useItem(&probe, action); err != nil {
+			return state, nil, err
+		}
+	case ActionChange:
+		i := next.benchIndex(action.Player, action.PokemonID)
+		if i < 0 {
+			return state, nil, ErrInvalidPokemon
+		}
+		for slot, pending := range next.Pending[action.Player] {
+			if slot+1 != action.Slot && pending.Kind == ActionChange && pending.PokemonID == action.PokemonID {
+				return state, nil, ErrInvalidPokemon
+			}
+		}
+	default:
+		return state, nil, ErrInvalidAction
+	}
+	next.Pending[action.Player][action.Slot-1] = action
+
+	if len(next.Waiting()) > 0 {
+		return next, nil, nil
+	}
+	return next, e.playTurn(&next), nil
+}
+
+// sendOut replaces a fainted pokemon between two turns
+func (e *Engine) sendOut(state *BattleState, action Action) (BattleState, []Event, error) {
+	slot := action.Slot
+	if slot == 0 { // "@change pokemonID" fills the first empty slot
+		if fainted := state.FaintedSlots(action.Player); len(fainted) > 0 {
+			slot = fainted[0]
+		}
+	}
+	i := state.benchIndex(action.Player, action.PokemonID)
+	if i < 0 || slot < 1 || slot > len(state.Slots[action.Player]) || state.SlotPokemon(action.Player, slot) != nil {
+		return *state, nil, ErrInvalidPokemon
+	}
+	state.Slots[action.Player][slot-1] = i
+	p := &state.Teams[action.Player][i]
+	events := []Event{{Kind: EventSendOut, Player: action.Player, Pokemon: p.Name, Hp: p.Hp, Slot: slot, At: e.clock()}}
+	events = append(events, e.switchIn(state, fighter{action.Player, slot, p})...)
+
+	e.checkForcedSwitch(state, action.Player)
+	if state.ForcedSwitch[action.Player] {
+		return *state, events, nil
+	}
+	if len(state.ForcedSwitch) == 0 {
+		events = append(events, Event{Kind: EventTurn, At: e.clock()})
+	}
+	return *state, events, nil
+}
+
+// forfeit takes a player who left out of the battle, the others go on
+func (e *Engine) forfeit(state *BattleState, player string) []Event {
+	wasForced := len(state.ForcedSwitch) > 0
+	for i := range state.Teams[player] {
+		state.Teams[player][i].Hp = 0
+	}
+	for slot := range state.Slots[player] {
+		state.Slots[player][slot] = -1
+		state.Pending[player][slot] = Action{}
+	}
+	delete(state.ForcedSwitch, player)
+
+	events := []Event{{Kind: EventOut, Player: player, At: e.clock()}}
+	if win := e.checkWinner(state, player); win != nil {
+		return append(events, *win)
+	}
+	switch {
+	case wasForced && len(state.ForcedSwitch) == 0:
+		events = append(events, Event{Kind: EventTurn, At: e.clock()})
+	case !wasForced && len(state.Waiting()) == 0:
+		events = append(events, e.playTurn(state)...)
+	}
+	return events
+}
+
+// checkWinner ends the battle when only one side has pokemons left
+func (e *Engine) checkWinner(state *BattleState, loser string) *Event {
+	alive := state.aliveSides()
+	if len(alive) != 1 {
+		return nil
+	}
+	state.Winner = alive[0]
+	win := Event{Kind: EventWin, Player: alive[0], At: e.clock()}
+	if state.Format == Doubles {
+		win.Target = loser
+	}
+	return &win
+}
+
+type simultaneousActor struct {
+	action   Action
+	priority int
+	speed    int
+}
+
+// playTurn plays the actions of every slot, switches first, then the moves
+// of highest priority and the fastest pokemon first with ties broken by the
+// rng. Items are used with the switches. Protections and flinches last until
+// the end of the round.
+func (e *Engine) playTurn(state *BattleState) []Event {
+	var actors []simultaneousActor
+	for _, name := range state.Players {
+		for slot, action := range state.Pending[name] {
+			if action.Kind != "" {
+				action.Slot = slot + 1
+				move, _ := findMove(action.Move)
+				actors = append(actors, simultaneousActor{action, move.Priority, state.speed(state.SlotPokemon(name, slot+1))})
+			}
+		}
+		state.Pending[name] = make([]Action, len(state.Slots[name]))
+	}
+	e.rng.Shuffle(len(actors), func(i, j int) { actors[i], actors[j] = actors[j], actors[i] })
+	sort.SliceStable(actors, func(i, j int) bool {
+		if first := actors[i].action.Kind == ActionChange || actors[i].action.Kind == ActionItem; first != (actors[j].action.Kind == ActionChange || actors[j].action.Kind == ActionItem) {
+			return first
+		}
+		if actors[i].priority != actors[j].priority {
+			return actors[i].priority > actors[j].priority
+		}
+		return actors[i].speed > actors[j].speed
+	})
+	state.Turn++
+
+	var events []Event
+	for _, actor := range actors {
+		action := actor.action
+		pAtk := state.SlotPokemon(action.Player, action.Slot)
+		if pAtk == nil {
+			continue // fainted before acting
+		}
+		if action.Kind == ActionChange {
+			i := state.benchIndex(action.Player, action.PokemonID)
+			if i < 0 {
+				continue
+			}
+			leaveField(pAtk)
+			state.Slots[action.Player][action.Slot-1] = i
+			p := &state.Teams[action.Player][i]
+			events = append(events, Event{Kind: EventSwitch, Player: action.Player, Pokemon: p.Name, Hp: p.Hp, Slot: action.Slot, At: e.clock()})
+			events = append(events, e.switchIn(state, fighter{action.Player, action.Slot, p})...)
+			continue
+		}
+		if action.Kind == ActionItem {
+			used, err := e.useItem(state, action)
+			if err != nil {
+				used = []Event{e.fail(fighter{action.Player, action.Slot, pAtk}, Move{Name: action.Item})}
+			}
+			events = append(events, used...)
+			continue
+		}
+		if pAtk.Volatile.Flinched {
+			events = append(events, e.flinch(fighter{action.Player, action.Slot, pAtk}))
+			continue
+		}
+		var move *Move
+		if action.Kind == ActionMove {
+			m, _ := findMove(action.Move)
+			if !m.damaging() {
+				events = append(events, e.useMove(state, fighter{action.Player, action.Slot, pAtk}, m)...)
+				pAtk.Volatile.LastMove = m.Name
+				continue
+			}
+			move = &m
+		}
+		pAtk.Volatile.LastMove = EffectKey(action.Move)
+
+		for _, target := range state.targets(action.Player, action.Target) {
+			pRecive := state.SlotPokemon(target.player, target.slot)
+			events = append(events, e.strike(state, fighter{action.Player, action.Slot, pAtk}, fighter{target.player, target.slot, pRecive}, action.Target == "all", move)...)
+			if pRecive.Hp > 0 {
+				continue
+			}
+			faint, over := e.faint(state, target.player, pRecive.Name, target.slot)
+			if events = append(events, faint...); over {
+				return events
+			}
+		}
+	}
+
+	events = append(events, e.expireField(state)...)
+	for _, name := range state.Players {
+		for slot := 1; slot <= len(state.Slots[name]); slot++ {
+			p := state.SlotPokemon(name, slot)
+			if p == nil {
+				continue
+			}
+			p.Volatile.Protected, p.Volatile.Flinched = false, false
+			events = append(events, e.residual(state, name, p, slot)...)
+			if p.Hp > 0 {
+				events = append(events, e.endOfTurn(state, fighter{name, slot, p})...)
+				continue
+			}
+			faint, over := e.faint(state, name, p.Name, slot)
+			if events = append(events, faint...); over {
+				return events
+			}
+		}
+	}
+
+	forced := false
+	for _, name := range state.Players {
+		e.checkForcedSwitch(state, name)
+		if state.ForcedSwitch[name] {
+			forced = true
+			events = append(events, Event{Kind: EventTurn, Player: name, At: e.clock()})
+		}
+	}
+	if !forced {
+		events = append(events, Event{Kind: EventTurn, At: e.clock()})
+	}
+	return events
+}
+
+// faint reports a pokemon that fainted, and the player being out or the
+// battle being over when it was their last one
+func (e *Engine) faint(state *BattleState, player string, pokemon string, slot int) ([]Event, bool) {
+	events := []Event{{Kind: EventFaint, Player: player, Pokemon: pokemon, Slot: slot, At: e.clock()}}
+	if len(state.Remaining(player)) > 0 {
+		return events, false
+	}
+	if win := e.checkWinner(state, player); win != nil {
+		return append(events, *win), true
+	}
+	return append(events, Event{Kind: EventOut, Player: player, At: e.clock()}), false
+}
+
+// checkForcedSwitch makes player replace their fainted pokemons while they
+// have some on the bench, and empties the slots they cannot fill
+func (e *Engine) checkForcedSwitch(state *BattleState, player string) {
+	fainted := state.FaintedSlots(player)
+	if len(fainted) > 0 && len(state.Bench(player)) > 0 {
+		state.ForcedSwitch[player] = true
+		return
+	}
+	delete(state.ForcedSwitch, player)
+	for _, slot := range fainted {
+		state.Slots[player][slot-1] = -1
+	}
+}
+
+// validTarget tells if an attack of player can aim at target: a slot of the
+// opponent in doubles, a player of another side otherwise, or "all". Without
+// a target a pokemon alone in the field attacks the first opponent.
+func (s *BattleState) validTarget(player string, target string) bool {
+	if target == "all" {
+		return true
+	}
+	if s.Format == Doubles {
+		return target == "1" || target == "2"
+	}
+	if target == "" {
+		return true
+	}
+	side, ok := s.Sides[target]
+	return ok && side != s.Sides[player]
+}
+
+// targets gives the pokemons an attack of player hits: the target, another
+// opponent if the target fainted, or every opponent for "all"
+func (s *BattleState) targets(player string, target string) []position {
+	var alive []position
+	for _, name := range s.Opponents(player) {
+		for slot := 1; slot <= len(s.Slots[name]); slot++ {
+			if s.SlotPokemon(name, slot) != nil {
+				alive = append(alive, position{name, slot})
+			}
+		}
+	}
+	if target == "all" || len(alive) == 0 {
+		return alive
+	}
+	for _, pos := range alive {
+		if pos.player == target || (s.Format == Doubles && target == strconv.Itoa(pos.slot)) {
+			return []position{pos}
+		}
+	}
+	return alive[:1]
+}
+
+// Opponents lists the players on the other sides
+func (s *BattleState) Opponents(player string) []string {
+	var opponents []string
+	for _, name := range s.Players {
+		if s.Sides[name] != s.Sides[player] {
+			opponents = append(opponents, name)
+		}
+	}
+	return opponents
+}
+
+// Teammates lists the other players on the side of player
+func (s *BattleState) Teammates(player string) []string {
+	var teammates []string
+	for _, name := range s.Players {
+		if name != player && s.Sides[name] == s.Sides[player] {
+			teammates = append(teammates, name)
+		}
+	}
+	return teammates
+}
+
+// aliveSides lists the sides that still have pokemons
+func (s *BattleState) aliveSides() []string {
+	var alive []string
+	seen := make(map[string]bool)
+	for _, name := range s.Players {
+		if side := s.Sides[name]; !seen[side] && len(s.Remaining(name)) > 0 {
+			seen[side] = true
+			alive = append(alive, side)
+		}
+	}
+	return alive
+}
+
+// SlotPokemon is the pokemon in a slot of player, nil when the slot is empty
+// or its pokemon fainted
+func (s *BattleState) SlotPokemon(player string, slot int) *BattlePokemon {
+	slots := s.Slots[player]
+	if slot < 1 || slot > len(slots) || slots[slot-1] < 0 {
+		return nil
+	}
+	p := &s.Teams[player][slots[slot-1]]
+	if p.Hp <= 0 {
+		return nil
+	}
+	return p
+}
+
+// FaintedSlots lists the slots of player whose pokemon fainted and was not replaced
+func (s *BattleState) FaintedSlots(player string) []int {
+	var fainted []int
+	for slot, i := range s.Slots[player] {
+		if i >= 0 && s.Teams[player][i].Hp <= 0 {
+			fainted = append(fainted, slot+1)
+		}
+	}
+	return fainted
+}
+
+// MissingSlots lists the slots of player that still need an action this turn
+func (s *BattleState) MissingSlots(player string) []int {
+	var missing []int
+	for slot := range s.Slots[player] {
+		if s.SlotPokemon(player, slot+1) != nil && s.Pending[player][slot].Kind == "" {
+			missing = append(missing, slot+1)
+		}
+	}
+	return missing
+}
+
+// Bench lists the pokemons of player that can be sent in
+func (s *BattleState) Bench(player string) []BattlePokemon {
+	var bench []BattlePokemon
+	for i, p := range s.Teams[player] {
+		if p.Hp > 0 && !s.inSlot(player, i) {
+			bench = append(bench, p)
+		}
+	}
+	return bench
+}
+
+func (s *BattleState) benchIndex(player string, id string) int {
+	i := s.pokemonIndex(player, id)
+	if i < 0 || s.Teams[player][i].Hp <= 0 || s.inSlot(player, i) {
+		return -1
+	}
+	return i
+}
+
+func (s *BattleState) inSlot(player string, i int) bool {
+	for _, j := range s.Slots[player] {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
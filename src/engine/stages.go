@@ -0,0 +1,133 @@
+package engine
+
+// Attacks can miss and land critical hits. Every attack rolls its accuracy,
+// then its critical hit chance. The accuracy of a plain attack is:
+//
+//	physical attack  95% accuracy
+//	special attack   90% accuracy
+//
+// The accuracy is changed by the accuracy stage of the attacker minus the
+// evasion stage of the target (x4/3 for +1, x3/4 for -1 and so on) and by
+// abilities and items. A critical hit does 1.5 times the damage and ignores
+// the attack drops of the attacker and the defense boosts of the target. Its
+// chance is 1/24, then 1/8, 1/2 and always with each critical hit stage.
+//
+// Stages go from -6 to +6 and are lost when the pokemon leaves the field.
+// Attack, defense and speed stages multiply the stat by (2+stage)/2 when
+// positive and by 2/(2-stage) when negative. Moves change them, see moves.go:
+//
+//	@move doubleteam  evasion +1       @move sandattack  accuracy -1 of the opponents
+//	@move minimize    evasion +2       @move smokescreen accuracy -1 of the opponents
+//	@move focusenergy critical hit +2  @move swordsdance attack +2
+//	@move harden      defense +1       @move agility     speed +2
+//	@move growl       attack -1 of the opponents
+type Stages struct {
+	Atk      int
+	Def      int
+	SpAtk    int
+	SpDef    int
+	Speed    int
+	Accuracy int
+	Evasion  int
+	Crit     int
+}
+
+const maxStage = 6
+
+// moveAccuracy is the accuracy in percent of the two kinds of attacks
+var moveAccuracy = map[bool]float64{true: 95, false: 90}
+
+// critChances are the 1 in n chances of a critical hit for each stage
+var critChances = []int{24, 8, 2, 1}
+
+// stage gives the stage of a stat, nil for an unknown stat
+func (s *Stages) stage(stat string) *int {
+	switch stat {
+	case "attack":
+		return &s.Atk
+	case "defense":
+		return &s.Def
+	case "special attack":
+		return &s.SpAtk
+	case "special defense":
+		return &s.SpDef
+	case "speed":
+		return &s.Speed
+	case "accuracy":
+		return &s.Accuracy
+	case "evasion":
+		return &s.Evasion
+	case "critical hit ratio":
+		return &s.Crit
+	}
+	return nil
+}
+
+// raise changes a stage of the pokemon target, because of a move of user,
+// and tells by how much it really changed. The move fails on an unknown stat.
+func (e *Engine) raise(user fighter, target fighter, stat string, change int, move string) Event {
+	stage := target.p.Stages.stage(stat)
+	if stage == nil {
+		return Event{Kind: EventFail, Player: user.player, Pokemon: user.p.Name, Slot: user.slot, Move: move, At: e.clock()}
+	}
+	before := *stage
+	*stage += change
+	if *stage > maxStage {
+		*stage = maxStage
+	}
+	if *stage < -maxStage {
+		*stage = -maxStage
+	}
+	return Event{Kind: EventStat, Player: user.player, Pokemon: user.p.Name, Slot: user.slot, Move: move, Effect: stat, Change: *stage - before,
+		Target: target.player, TargetPokemon: target.p.Name, TargetSlot: target.slot, At: e.clock()}
+}
+
+// staged is a stat at a stage
+func staged(stat int, stage int) int {
+	if stage >= 0 {
+		return stat * (2 + stage) / 2
+	}
+	return stat * 2 / (2 - stage)
+}
+
+// accuracyMultiplier is the accuracy multiplier of a stage, x4/3 for +1
+func accuracyMultiplier(stage int) float64 {
+	if stage > maxStage {
+		stage = maxStage
+	}
+	if stage < -maxStage {
+		stage = -maxStage
+	}
+	if stage >= 0 {
+		return float64(3+stage) / 3
+	}
+	return 3 / float64(3-stage)
+}
+
+// hits rolls the accuracy of an attack, accuracy being in percent
+func (e *Engine) hits(state *BattleState, h *hit, accuracy float64) bool {
+	accuracy *= accuracyMultiplier(h.attacker.p.Stages.Accuracy - h.target.p.Stages.Evasion)
+	for _, self := range []fighter{h.attacker, h.target} {
+		for _, ef := range effectsOf(self.p) {
+			if ef.accuracy != nil {
+				accuracy *= ef.accuracy(state, self, h)
+			}
+		}
+	}
+	return e.rng.Float64()*100 < accuracy
+}
+
+// critical rolls the critical hit chance of an attacker
+func (e *Engine) critical(p *BattlePokemon) bool {
+	stage := p.Stages.Crit
+	for _, ef := range effectsOf(p) {
+		stage += ef.critStages
+	}
+	if stage < 0 {
+		stage = 0
+	}
+	if stage >= len(critChances) {
+		stage = len(critChances) - 1
+	}
+	return e.rng.Intn(critChances[stage]) == 0
+}
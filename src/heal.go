@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// In a ruleset with injuries (the built-in "injury" one, or "Injuries": true
+// in a -rulesets file) every pokemon starts the battle with the HP it had
+// left after its last one, and the exact HP it lost is written back to the
+// player store when the battle is over. Only HP carries over, there are no
+// status conditions. A fainted pokemon cannot be picked in such
+// a ruleset until it is healed. The other rulesets ignore injuries: every
+// pokemon fights with full HP and keeps its injuries for later.
+//
+// "@heal" takes every pokemon of the player to the Pokécenter, which heals
+// the HP of them all once every healCooldown.
+
+var healCooldown = flag.Duration("heal-cooldown", 10*time.Minute, "time a player has to wait between two @heal, which heals the HP lost in battles with injuries")
+
+// injuredHp is the HP a pokemon of a player has left
+func injuredHp(p *PlayerPokeInfo) int {
+	if p.Damage >= p.Hp {
+		return 0
+	}
+	return p.Hp - p.Damage
+}
+
+// describeHp shows the HP of a pokemon of a player, "30/45" when injured
+func describeHp(p PlayerPokeInfo) string {
+	switch {
+	case p.Damage == 0:
+		return fmt.Sprint(p.Hp)
+	case injuredHp(&p) == 0:
+		return fmt.Sprintf("0/%d, fainted", p.Hp)
+	}
+	return fmt.Sprintf("%d/%d", injuredHp(&p), p.Hp)
+}
+
+// carryInjuries writes the HP the pokemons lost in a battle with injuries
+// back to the player store
+func carryInjuries(battle *Battle) {
+	if !battle.Rules.Injuries {
+		return
+	}
+	for name, team := range battle.State.Teams {
+		for _, bp := range team {
+			p := ownedPokemon(name, bp.ID)
+			if p == nil || bp.MaxHp == 0 {
+				continue // AI trainers and random teams
+			}
+			p.Damage = injury(bp.MaxHp-bp.Hp, p.Hp, bp.Hp == 0)
+		}
+	}
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+}
+
+// injury is the HP a pokemon with hp HP lost, from 0 to hp. Its HP in a
+// battle can differ from the one in the store (rulesets with a level), so a
+// pokemon that did not faint keeps at least 1 HP, and one that fainted loses
+// all of them.
+func injury(lost int, hp int, fainted bool) int {
+	if fainted {
+		return hp
+	}
+	if lost >= hp {
+		lost = hp - 1
+	}
+	if lost < 0 {
+		lost = 0
+	}
+	return lost
+}
+
+// healAll heals every pokemon of a player at the Pokécenter
+func healAll(player string, now time.Time) (string, error) {
+	record := findPlayerRecord(player)
+	if isInBattle(player) {
+		return "", fmt.Errorf("pokemons cannot go to the Pokécenter during a battle")
+	}
+	if record.HealedAt != nil && now.Sub(*record.HealedAt) < *healCooldown {
+		wait := record.HealedAt.Add(*healCooldown).Sub(now).Round(time.Second)
+		return "", fmt.Errorf("the Pokécenter can heal your pokemons again in %s", wait)
+	}
+	healed := 0
+	for i := range record.PlayerPokeInfo {
+		if record.PlayerPokeInfo[i].Damage > 0 {
+			record.PlayerPokeInfo[i].Damage = 0
+			healed++
+		}
+	}
+	if healed == 0 {
+		return "Your pokemons are all in good health.", nil
+	}
+	record.HealedAt = &now
+	return fmt.Sprintf("The Pokécenter healed %d pokemons to full HP, come back in %s.", healed, *healCooldown), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestInjury(t *testing.T) {
+	tests := []struct {
+		name    string
+		lost    int
+		hp      int
+		fainted bool
+		want    int
+	}{
+		{"unhurt", 0, 45, false, 0},
+		{"hurt", 20, 45, false, 20},
+		{"1 HP left", 44, 45, false, 44},
+		{"more lost than the store HP", 80, 45, false, 44},
+		{"fainted", 45, 45, true, 45},
+		{"fainted with less HP in the battle", 30, 45, true, 45},
+		{"healed above the start", -5, 45, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := injury(tt.lost, tt.hp, tt.fainted); got != tt.want {
+				t.Errorf("injury(%d, %d, %v) = %d, want %d", tt.lost, tt.hp, tt.fainted, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"./engine"
+)
+
+// Every player has a bag, kept in the player store, that they fill at the
+// shop with the coins of their wallet (see rewards.go): "@shop" lists what
+// is for sale, "@buy item [count]" buys and "@bag" shows the bag.
+//
+//   - medicine: potions heal HP, revives bring a fainted pokemon back. In a
+//     battle "@use item [pokemonID]" uses one on the pokemon in the field or
+//     on pokemonID and costs the turn, out of battles it heals injuries (see
+//     heal.go).
+//   - held items: "@hold pokemonID item" takes one out of the bag, the item
+//     the pokemon held before goes back in.
+//   - balls and evolution items are kept for catching and evolving pokemons,
+//     which the server does not have yet.
+type BagItem struct {
+	Name        string
+	Category    string
+	Price       int
+	Description string
+}
+
+const (
+	Medicine  = "medicine"
+	Ball      = "ball"
+	Held      = "held item"
+	Evolution = "evolution item"
+)
+
+// shopCategories is the order of the categories in the shop
+var shopCategories = []string{Medicine, Held, Ball, Evolution}
+
+var shopItems = map[string]BagItem{
+	"potion":       {Name: "Potion", Category: Medicine, Price: 200, Description: "heals 20 HP"},
+	"superpotion":  {Name: "Super Potion", Category: Medicine, Price: 700, Description: "heals 60 HP"},
+	"hyperpotion":  {Name: "Hyper Potion", Category: Medicine, Price: 1500, Description: "heals 120 HP"},
+	"revive":       {Name: "Revive", Category: Medicine, Price: 2000, Description: "brings a fainted pokemon back with half its HP"},
+	"maxrevive":    {Name: "Max Revive", Category: Medicine, Price: 4000, Description: "brings a fainted pokemon back with all its HP"},
+	"pokeball":     {Name: "Poke Ball", Category: Ball, Price: 200, Description: "for catching pokemons"},
+	"greatball":    {Name: "Great Ball", Category: Ball, Price: 600, Description: "catches better than a Poke Ball"},
+	"ultraball":    {Name: "Ultra Ball", Category: Ball, Price: 800, Description: "catches better than a Great Ball"},
+	"firestone":    {Name: "Fire Stone", Category: Evolution, Price: 2100, Description: "evolves some fire pokemons"},
+	"waterstone":   {Name: "Water Stone", Category: Evolution, Price: 2100, Description: "evolves some water pokemons"},
+	"thunderstone": {Name: "Thunder Stone", Category: Evolution, Price: 2100, Description: "evolves some electric pokemons"},
+	"leafstone":    {Name: "Leaf Stone", Category: Evolution, Price: 2100, Description: "evolves some grass pokemons"},
+	"moonstone":    {Name: "Moon Stone", Category: Evolution, Price: 2100, Description: "evolves some pokemons"},
+}
+
+// heldItemPrices are the prices of the held items that do not cost
+// defaultHeldItemPrice
+var heldItemPrices = map[string]int{"oranberry": 100, "sitrusberry": 300, "focussash": 1500, "lifeorb": 2000}
+
+const defaultHeldItemPrice = 1000
+
+func init() {
+	for key, item := range engine.HeldItems {
+		price, ok := heldItemPrices[key]
+		if !ok {
+			price = defaultHeldItemPrice
+		}
+		shopItems[key] = BagItem{Name: item.Name, Category: Held, Price: price, Description: item.Description}
+	}
+}
+
+// findBagItem finds an item of the shop whatever its case and spaces
+func findBagItem(name string) (string, *BagItem) {
+	key := engine.EffectKey(name)
+	item, ok := shopItems[key]
+	if !ok {
+		return "", nil
+	}
+	return key, &item
+}
+
+// usableInBattle tells if an item can be used with "@use" in a battle, see
+// engine/items.go
+func (item BagItem) usableInBattle() bool {
+	return engine.FindMedicine(item.Name) != nil
+}
+
+// shop lists the items for sale, of one category or of all of them
+func shop(player string, category string) string {
+	lines := []string{fmt.Sprintf("Shop, buy with @buy item [count]. Your wallet: %d coins", findPlayerRecord(player).Coins)}
+	for _, c := range shopCategories {
+		if category != "" && !strings.HasPrefix(c, category) {
+			continue
+		}
+		var items []string
+		for key, item := range shopItems {
+			if item.Category == c {
+				items = append(items, fmt.Sprintf("  %s (%s): %d coins, %s", item.Name, key, item.Price, item.Description))
+			}
+		}
+		sort.Strings(items)
+		lines = append(lines, strings.ToUpper(c[:1])+c[1:]+"s:")
+		lines = append(lines, items...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// buy takes count items of the shop for the coins of the player
+func buy(player string, args []string) (string, error) {
+	count := 1
+	if len(args) > 1 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			count, args = n, args[:len(args)-1]
+		}
+	}
+	key, item := findBagItem(strings.Join(args, " "))
+	switch {
+	case item == nil:
+		return "", fmt.Errorf("the shop has no %s, see @shop", strings.Join(args, " "))
+	case count < 1 || count > 99:
+		return "", fmt.Errorf("you can buy 1 to 99 items at once")
+	}
+	record := findPlayerRecord(player)
+	if cost := item.Price * count; record.Coins < cost {
+		return "", fmt.Errorf("%d %s cost %d coins, you have %d", count, item.Name, cost, record.Coins)
+	}
+	record.Coins -= item.Price * count
+	addToBag(record, key, count)
+	return fmt.Sprintf("You bought %d %s for %d coins. Wallet: %d coins", count, item.Name, item.Price*count, record.Coins), nil
+}
+
+func addToBag(record *PlayerPokemon, key string, count int) {
+	if record.Bag == nil {
+		record.Bag = make(map[string]int)
+	}
+	record.Bag[key] += count
+}
+
+// takeFromBag takes an item out of the bag of a player
+func takeFromBag(player string, key string) error {
+	record := findPlayerRecord(player)
+	if record.Bag[key] < 1 {
+		return fmt.Errorf("you have no %s in your bag, see @shop", shopItems[key].Name)
+	}
+	record.Bag[key]--
+	if record.Bag[key] == 0 {
+		delete(record.Bag, key)
+	}
+	return nil
+}
+
+// bag lists the items of a player
+func bag(player string) string {
+	record := findPlayerRecord(player)
+	if len(record.Bag) == 0 {
+		return fmt.Sprintf("Your bag is empty. Wallet: %d coins, see @shop", record.Coins)
+	}
+	var lines []string
+	for key, count := range record.Bag {
+		item := shopItems[key]
+		lines = append(lines, fmt.Sprintf("  %s (%s) x%d, %s", item.Name, key, count, item.Category))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("Your bag (wallet: %d coins):\n%s", record.Coins, strings.Join(lines, "\n"))
+}
+
+// useOutside uses a medicine on an injured pokemon out of battles
+func useOutside(player string, name string, id string) (string, error) {
+	key, item := findBagItem(name)
+	if item == nil || !item.usableInBattle() {
+		return "", fmt.Errorf("%s cannot be used on a pokemon", name)
+	}
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	medicine := engine.FindMedicine(item.Name)
+	fainted := injuredHp(p) == 0
+	if p.Damage == 0 || medicine.Revive > 0 && !fainted || medicine.Heal > 0 && fainted {
+		return "", engine.ErrNoEffect
+	}
+	if err := takeFromBag(player, key); err != nil {
+		return "", err
+	}
+	if medicine.Revive > 0 {
+		p.Damage = p.Hp - p.Hp*medicine.Revive/100
+	} else if p.Damage -= medicine.Heal; p.Damage < 0 {
+		p.Damage = 0
+	}
+	return fmt.Sprintf("You used a %s on %s, HP: %s", item.Name, id, describeHp(*p)), nil
+}
+
+// checkBag makes sure a player has the item of a battle action, counting the
+// ones their other slots will already use this turn
+func checkBag(battle *Battle, action engine.Action) error {
+	key, item := findBagItem(action.Item)
+	if item == nil || !item.usableInBattle() {
+		return fmt.Errorf("%s cannot be used in a battle", action.Item)
+	}
+	if action.Slot == 0 {
+		action.Slot = 1
+	}
+	needed := 1
+	for slot, pending := range battle.State.Pending[action.Player] {
+		if slot+1 != action.Slot && pending.Kind == engine.ActionItem && engine.EffectKey(pending.Item) == key {
+			needed++
+		}
+	}
+	if findPlayerRecord(action.Player).Bag[key] < needed {
+		return fmt.Errorf("you have no %s left in your bag", item.Name)
+	}
+	return nil
+}
+
+// spendItems takes the items used in a battle out of the bags
+func spendItems(events []engine.Event) {
+	spent := false
+	for _, event := range events {
+		if event.Kind != engine.EventUse {
+			continue
+		}
+		if key, item := findBagItem(event.Effect); item != nil && takeFromBag(event.Player, key) == nil {
+			spent = true
+		}
+	}
+	if !spent {
+		return
+	}
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+}
+
+// spendHeldItems takes the held items used up in a battle (berries, Focus
+// Sash) from the pokemons of the player store: the engine only takes them
+// from the battle pokemons
+func spendHeldItems(battle *Battle) {
+	if battle.Rules.RandomTeams {
+		return // the teams come from the pokedex
+	}
+	spent := false
+	for name, team := range battle.State.Teams {
+		for _, bp := range team {
+			p := ownedPokemon(name, bp.ID)
+			if p == nil || p.Name != bp.Name || p.Item == "" || bp.Item != "" {
+				continue // AI trainers, or the item is still there
+			}
+			p.Item = ""
+			spent = true
+		}
+	}
+	if !spent {
+		return
+	}
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+}
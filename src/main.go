@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"./engine"
 )
 
 const (
@@ -16,100 +21,95 @@ const (
 	TYPE               = "udp"
 	pokedexData        = "src\\pokedex.json"
 	playerpokemonsData = "src\\playersPokemon.json"
+	battleArchiveData  = "src\\battles.json"
+	replayDir          = "src\\replays"
 )
 
 type (
 	Pokemon struct {
-		Id       string   `json:"ID"`
-		Name     string   `json:"Name"`
-		Link     string   `json:"URL"`
-		PokeInfo PokeInfo `json:"Poke-Information"`
+		Id        string   `json:"ID"`
+		Name      string   `json:"Name"`
+		Types     []string `json:"types"`
+		Abilities []string `json:"Abilities,omitempty"` // abilities the species can have, the first one by default, see speciesabilities.go
+		Link      string   `json:"URL"`
+		PokeInfo  PokeInfo `json:"Poke-Information"`
 	}
 
 	PokeInfo struct {
-		Types       []string `json:"types"`
-		Hp          int      `json:"HP"`
-		Atk         int      `json:"ATK"`
-		Def         int      `json:"DEF"`
-		SpAtk       int      `json:"Sp.Atk"`
-		SpDef       int      `json:"Sp.Def"`
-		Speed       int      `json:"Speed"`
-		TypeDefense TypeDef  `json:"Type-Defenses"`
-	}
-	TypeDef struct {
-		Normal   float32 `json:"Normal"`
-		Fire     float32 `json:"Fire"`
-		Water    float32 `json:"Water"`
-		Electric float32 `json:"Electric"`
-		Grass    float32 `json:"Grass"`
-		Ice      float32 `json:"Ice"`
-		Fighting float32 `json:"Fighting"`
-		Poison   float32 `json:"Poison"`
-		Ground   float32 `json:"Ground"`
-		Flying   float32 `json:"Flying"`
-		Psychic  float32 `json:"Psychic"`
-		Bug      float32 `json:"Bug"`
-		Rock     float32 `json:"Rock"`
-		Ghost    float32 `json:"Ghost"`
-		Dragon   float32 `json:"Dragon"`
-		Dark     float32 `json:"Dark"`
-		Steel    float32 `json:"Steel"`
-		Fairy    float32 `json:"Fairy"`
+		Types       []string       `json:"types"`
+		Hp          int            `json:"HP"`
+		Atk         int            `json:"ATK"`
+		Def         int            `json:"DEF"`
+		SpAtk       int            `json:"Sp.Atk"`
+		SpDef       int            `json:"Sp.Def"`
+		Speed       int            `json:"Speed"`
+		TypeDefense engine.TypeDef `json:"Type-Defenses"`
 	}
 
 	Player struct {
 		Name                  string `json:"PlayerName"`
 		Addr                  *net.UDPAddr
 		Pokemons              map[string]PlayerPokemon // string là pokemon ID
-		BattlePokemon         map[string]BattlePokemon
+		BattlePokemon         map[string]engine.BattlePokemon
 		battleRequestSends    map[string]string // store number of request that a player send: 'map[receivers]sender'
 		battleRequestReceives map[string]string // store number of request that a player get: 'map[senders]receiver'
 		Active                string
 		battleID              int64
+		spectating            int64      // battle the player is watching
+		ai                    *AITrainer // set for AI trainers, who have no address
 	}
 
 	PlayerPokemon struct { // store pokemmon that a player holding
 		Owner          string           `json:"PlayerName"`
 		PlayerPokeInfo []PlayerPokeInfo `json:"Pokemons"`
+		Rating         int              `json:"Rating,omitempty"` // Elo rating, 0 until the first rated battle
+		Stats          *PlayerStats     `json:"Stats,omitempty"`
+		HealedAt       *time.Time       `json:"HealedAt,omitempty"` // last @heal, see heal.go
+		Coins          int              `json:"Coins,omitempty"`    // wallet, see rewards.go
+		Bag            map[string]int   `json:"Bag,omitempty"`      // count of every item of the shop the player has, see inventory.go
 	}
 	PlayerPokeInfo struct { // store pokemmon that a player holding
-		ID          string   `json:"ID"`
-		Name        string   `json:"Name"`
-		Level       int      `json:"Level"`
-		Exp         int      `json:"Exp"`
-		Types       []string `json:"types"`
-		Hp          int      `json:"HP"`
-		Atk         int      `json:"ATK"`
-		Def         int      `json:"DEF"`
-		SpAtk       int      `json:"Sp.Atk"`
-		SpDef       int      `json:"Sp.Def"`
-		Speed       int      `json:"Speed"`
-		TypeDefense TypeDef  `json:"Type-Defenses"`
-	}
-
-	BattlePokemon struct {
-		Name        string `json:"Name"`
-		ID          string
-		Level       int
-		Exp         int
-		Types       []string `json:"types"`
-		Hp          int      `json:"HP"`
-		Atk         int      `json:"ATK"`
-		Def         int      `json:"DEF"`
-		SpAtk       int      `json:"Sp.Atk"`
-		SpDef       int      `json:"Sp.Def"`
-		Speed       int      `json:"Speed"`
-		TypeDefense TypeDef  `json:"Type-Defenses"`
+		ID          string         `json:"ID"`
+		Name        string         `json:"Name"`
+		Level       int            `json:"Level"`
+		Exp         int            `json:"Exp"`
+		Types       []string       `json:"types,omitempty"`
+		Hp          int            `json:"HP"`
+		Atk         int            `json:"ATK"`
+		Def         int            `json:"DEF"`
+		SpAtk       int            `json:"S.Atk"` // the store says S.Atk where the pokedex says Sp.Atk
+		SpDef       int            `json:"S.Def"`
+		Speed       int            `json:"Speed"`
+		TypeDefense engine.TypeDef `json:"Type-Defenses,omitzero"`
+		Ability     string         `json:"Ability,omitempty"`  // the first ability of the species when empty
+		Item        string         `json:"Item,omitempty"`     // held item, see engine/abilities.go
+		Damage      int            `json:"Damage,omitempty"`   // HP lost in battles with injuries, see heal.go
+		Nickname    string         `json:"Nickname,omitempty"` // see collection.go
+		Favorite    bool           `json:"Favorite,omitempty"`
 	}
 
 	Battle struct {
-		battleID       int64
-		Players        map[string]*Player
-		ActivePokemons map[string]*BattlePokemon // Store active Pokemons in the battle
-		BeatingPokemon map[string]*BattlePokemon
-		CurrentTurn    string
-		Status         string
-		PokemonCounter map[string]int
+		battleID   int64
+		Players    map[string]*Player
+		Picks      map[string][]engine.BattlePokemon // pokemons each player picked, in pick order
+		Challenger string                            // player who sent the battle request
+		Format     engine.BattleFormat
+		Rules      Ruleset           // teams that can be picked
+		draft      *Draft            // bans and picks so far, in draft battles
+		Sides      map[string]string // side of every player in team battles
+		Accepted   map[string]bool   // players who accepted a battle of more than two players
+		Ranked     bool              // matched from the queue
+		tournament *Tournament       // tournament the battle is a match of
+		Spectators map[string]bool
+		Status     BattleStatus
+		State      engine.BattleState // the fight itself, once both players picked
+		Seed       int64              // seed of the engine, kept so the battle can be replayed
+		engine     *engine.Engine
+		replay     *Replay
+		StartedAt  time.Time
+		Timeouts   map[string]int // number of turns a player let run out
+		timer      *time.Timer
+		timerSeq   int
 	}
 )
 
@@ -119,10 +119,12 @@ var playersPokemons []PlayerPokemon // player's Pokemons
 
 var players = make(map[string]*Player) // list of player online
 
-var inBattleWith = make(map[string]string) // check player is in battle or not
-
 var gameStates = make(map[int64]*Battle) // battles
 
+var mu sync.Mutex // guards the game state, messages and timers run concurrently
+
+var movesFile = flag.String("moves", "", "JSON file with a list of moves to add to the built-in ones, see engine/moves.go")
+
 func loadPlayerPokemon(filename string) error {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -132,6 +134,14 @@ func loadPlayerPokemon(filename string) error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replayCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(simulateCommand(os.Args[2:]))
+	}
+	flag.Parse()
+
 	// Load the pokedex data from the JSON file
 	err := loadPokedex(pokedexData)
 	if err != nil {
@@ -143,6 +153,20 @@ func main() {
 		fmt.Println("Error loading pokedex data:", err)
 	}
 
+	if *rulesetsFile != "" {
+		if err := loadRulesets(*rulesetsFile); err != nil {
+			fmt.Println("Error loading rulesets:", err)
+			return
+		}
+	}
+
+	if *movesFile != "" {
+		if err := engine.LoadMoves(*movesFile); err != nil {
+			fmt.Println("Error loading moves:", err)
+			return
+		}
+	}
+
 	udpAddr, err := net.ResolveUDPAddr(TYPE, HOST+":"+PORT)
 	if err != nil {
 		fmt.Println("Error resolving UDP address:", err)
@@ -158,6 +182,9 @@ func main() {
 
 	fmt.Println("Pokemon game has been running on", udpAddr)
 
+	go runMatchmaking(2*time.Second, conn)
+	go runTournaments(2*time.Second, conn)
+
 	buffer := make([]byte, 1024)
 
 	for {
@@ -173,6 +200,12 @@ func main() {
 }
 
 func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
+	mu.Lock()
+	defer mu.Unlock()
+	processMessage(message, addr, conn)
+}
+
+func processMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 
 	fmt.Println(message)
 
@@ -180,6 +213,10 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 		parts := strings.SplitN(message, " ", 2)
 		command := parts[0]
 		senderName := getPlayernameByAddr(addr) // Get sender's name
+		if senderName == "" && command != "@join" {
+			sendMessage("Please @join first!", addr, conn)
+			return
+		}
 
 		if !isInBattle(senderName) {
 			switch command {
@@ -207,6 +244,10 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 			case "@all":
 				broadcastMessage(parts[1], senderName, conn) // Pass sender's name
 			case "@quit":
+				unspectate(senderName, conn)
+				leaveQueue(senderName)
+				leaveBattles(senderName, conn)
+				cancelTrade(senderName, conn)
 				delete(players, senderName)
 				fmt.Printf("User '%s' left\n", senderName)
 				sendMessage("Goodbye '"+senderName+"'!", addr, conn)
@@ -232,9 +273,59 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 					break
 				}
 
-				temp := parts[1]
-				nextPart := strings.SplitN(temp, " ", 2)
-				opponent := nextPart[0]
+				fields := strings.Fields(parts[1])
+				rulesName := defaultRuleset
+				for i, field := range fields {
+					if strings.HasPrefix(field, "rules=") {
+						rulesName = strings.TrimPrefix(field, "rules=")
+						fields = append(fields[:i], fields[i+1:]...)
+						break
+					}
+				}
+				rules, ok := rulesets[rulesName]
+				if !ok {
+					sendMessage("Error: Unknown ruleset, see @rules", addr, conn)
+					break
+				}
+				if len(fields) == 0 {
+					sendMessage("Invalid command", addr, conn)
+					break
+				}
+				opponent := fields[0]
+
+				if opponent == "ai" {
+					level := AINormal
+					if len(fields) == 2 {
+						level = AILevel(fields[1])
+					}
+					startAIBattle(senderName, level, conn)
+					break
+				}
+
+				format := engine.Singles
+				if len(fields) > 1 {
+					format = engine.BattleFormat(fields[len(fields)-1])
+					fields = fields[:len(fields)-1]
+				}
+				if format == "random" || format == "draft" { // "@battle opponent random" is a singles random battle
+					format, rules = engine.Singles, rulesets[string(format)]
+				}
+				if format == engine.TeamBattle || format == engine.FreeForAll {
+					requestMultiBattle(senderName, fields, format, rules, conn)
+					break
+				}
+				if format != engine.Singles && format != engine.Doubles {
+					sendMessage("Error: The format must be singles, doubles, team or ffa!", addr, conn)
+					break
+				}
+				if len(fields) != 1 {
+					sendMessage("Invalid command", addr, conn)
+					break
+				}
+				if !rules.allowsFormat(format) {
+					sendMessage("Error: The "+rules.Name+" ruleset has too few pokemons for "+string(format)+"!", addr, conn)
+					break
+				}
 
 				if opponent == senderName {
 					sendMessage("Invalid command", addr, conn)
@@ -250,10 +341,24 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 					break
 				}
 
+				if findBattleRequest(senderName, opponent) != nil {
+					sendMessage("You already sent a battle request to '"+opponent+"'!", addr, conn)
+					break
+				}
+
 				players[senderName].battleRequestSends[opponent] = senderName
 				players[opponent].battleRequestReceives[senderName] = opponent
+				battle := newBattle(senderName, opponent)
+				battle.Format = format
+				battle.Rules = rules
 
 				battleRequestMessage := "Player '" + senderName + "' requests you a pokemon battle!"
+				if format == engine.Doubles {
+					battleRequestMessage = "Player '" + senderName + "' requests you a doubles pokemon battle!"
+				}
+				if rules.Name != defaultRuleset {
+					battleRequestMessage += " Rules: " + rules.String()
+				}
 				sendMessage(battleRequestMessage, players[opponent].Addr, conn)
 			case "@accept":
 				if len(parts) < 2 {
@@ -265,53 +370,37 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 				nextPart := strings.Split(temp, " ")
 				opponent := nextPart[0]
 
+				if !checkExistedPlayer(opponent) {
+					sendMessage("Error: Opponent did not exist in the server!", addr, conn)
+					break
+				}
+				if isInBattle(opponent) {
+					sendMessage("Error: Opponent is already in a battle!", addr, conn)
+					break
+				}
+
 				if players[senderName].battleRequestReceives[opponent] == senderName &&
 					players[opponent].battleRequestSends[senderName] == opponent {
 
-					inBattleWith[senderName] = opponent
-					inBattleWith[opponent] = senderName
-
 					delete(players[opponent].battleRequestSends, senderName)
 					delete(players[senderName].battleRequestReceives, opponent)
 
-					var id = getNanoTime()
-
-					gameStates[id] = &Battle{
-						battleID:       id,
-						Players:        make(map[string]*Player),
-						ActivePokemons: make(map[string]*BattlePokemon),
-						BeatingPokemon: make(map[string]*BattlePokemon),
-						CurrentTurn:    players[senderName].Name,
-						Status:         "waiting",
-						PokemonCounter: make(map[string]int),
-					}
-
-					gameStates[id].Players[senderName] = players[senderName]
-					gameStates[id].Players[opponent] = players[opponent]
-
-					players[senderName] = &Player{
-						battleID:              id,
-						Name:                  senderName,
-						Addr:                  addr,
-						battleRequestSends:    make(map[string]string),
-						battleRequestReceives: make(map[string]string),
+					battle := findBattleRequest(opponent, senderName)
+					if len(battle.Players) > 2 {
+						acceptMultiBattle(battle, senderName, conn)
+						break
 					}
-					players[opponent] = &Player{
-						battleID:              id,
-						Name:                  opponent,
-						Addr:                  players[opponent].Addr,
-						battleRequestSends:    make(map[string]string),
-						battleRequestReceives: make(map[string]string),
+					if err := acceptBattle(battle, conn); err != nil {
+						sendMessage("Error: "+err.Error(), addr, conn)
+						break
 					}
-
-					players[opponent].battleID = id
-					players[opponent].battleID = id
-
 					sendMessage("You accepted a battle with player '"+opponent+"'", addr, conn)
 					sendMessage("@accepted_battle", addr, conn)
 
 					sendMessage("Your battle request with player '"+senderName+"' is accepted!", players[opponent].Addr, conn)
 					sendMessage("@accepted_battle", players[opponent].Addr, conn)
+
+					startPickTimer(battle.battleID, conn)
 				} else {
 					sendMessage("Invalid acception! (WRONG opppent name or NOT RECEIVES battle request from this opponent)", addr, conn)
 				}
@@ -325,10 +414,12 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 				nextPart := strings.SplitN(temp, " ", 2)
 				opponent := nextPart[0]
 
-				if players[senderName].battleRequestReceives[opponent] == senderName &&
+				if checkExistedPlayer(opponent) &&
+					players[senderName].battleRequestReceives[opponent] == senderName &&
 					players[opponent].battleRequestSends[senderName] == opponent {
 					delete(players[opponent].battleRequestSends, senderName)
 					delete(players[senderName].battleRequestReceives, opponent)
+					abortBattle(findBattleRequest(opponent, senderName), conn)
 
 					sendMessage("You denied a battle with player '"+opponent+"'", addr, conn)
 					sendMessage("Your battle request to player '"+senderName+"' was dinied!", players[opponent].Addr, conn)
@@ -336,17 +427,189 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 					sendMessage("Invalid acception! (WRONG opppent name or NOT RECEIVES battle request from this opponent)", addr, conn)
 				}
 			case "@list":
-				// Find player Pokemons by player's name
-				playerPokemons := findPlayerPokemonByPlayer(senderName)
-				fmt.Printf("Pokémons of player %s:\n", senderName)
-				for _, pokemon := range playerPokemons {
-					str := fmt.Sprintf("Pokemon ID: %s, Name: %s, Level: %d, HP: %d\n", pokemon.ID, pokemon.Name, pokemon.Level, pokemon.Hp)
-					sendMessage("@list_pokemon_only"+str, addr, conn)
+				list, err := listPokemons(senderName, strings.Fields(message)[1:])
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
 				}
+				sendMessage("@list_pokemon_only"+list, addr, conn)
+			case "@nickname":
+				args := strings.Fields(message)
+				if len(args) < 2 {
+					sendMessage("Usage: @nickname pokemonID name (no name takes the nickname back)", addr, conn)
+					break
+				}
+				reply, err := setNickname(senderName, args[1], strings.Join(args[2:], " "))
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(reply, addr, conn)
+			case "@favorite":
+				args := strings.Fields(message)
+				if len(args) != 2 {
+					sendMessage("Usage: @favorite pokemonID", addr, conn)
+					break
+				}
+				reply, err := toggleFavorite(senderName, args[1])
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(reply, addr, conn)
+			case "@release":
+				if len(parts) < 2 {
+					parts = append(parts, "")
+				}
+				releaseCommand(parts[1], senderName, conn)
 
 			case "@pokedex":
 				parts = strings.Split(message, " ")
 				sendMessage("@pokedex"+pokedexScanner(parts[1]), addr, conn)
+			case "@battles":
+				sendMessage(listBattles(), addr, conn)
+			case "@rules":
+				sendMessage(listRulesets(), addr, conn)
+			case "@ability":
+				args := strings.Fields(message)
+				if len(args) < 3 {
+					sendMessage("Usage: @ability pokemonID name\nAbilities with an effect:\n"+engine.DescribeEffects(engine.Abilities), addr, conn)
+					break
+				}
+				ability, err := setAbility(senderName, args[1], strings.Join(args[2:], " "))
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(fmt.Sprintf("%s now has the ability %s.", args[1], ability), addr, conn)
+			case "@hold":
+				args := strings.Fields(message)
+				if len(args) < 3 {
+					sendMessage("Usage: @hold pokemonID item (or none), see @items", addr, conn)
+					break
+				}
+				item, err := holdItem(senderName, args[1], strings.Join(args[2:], " "))
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				if item == "" {
+					sendMessage(args[1]+" holds nothing now.", addr, conn)
+				} else {
+					sendMessage(fmt.Sprintf("%s now holds %s.", args[1], item), addr, conn)
+				}
+			case "@wallet":
+				sendMessage(wallet(senderName), addr, conn)
+			case "@bag":
+				sendMessage(bag(senderName), addr, conn)
+			case "@shop":
+				sendMessage(shop(senderName, strings.TrimSpace(strings.TrimPrefix(message, "@shop"))), addr, conn)
+			case "@buy":
+				args := strings.Fields(message)
+				if len(args) < 2 {
+					sendMessage("Usage: @buy item [count], see @shop", addr, conn)
+					break
+				}
+				reply, err := buy(senderName, args[1:])
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(reply, addr, conn)
+			case "@use":
+				args := strings.Fields(message)
+				if len(args) != 3 {
+					sendMessage("Usage: @use item pokemonID, see @bag", addr, conn)
+					break
+				}
+				reply, err := useOutside(senderName, args[1], args[2])
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(reply, addr, conn)
+			case "@heal":
+				reply, err := healAll(senderName, time.Now())
+				if err != nil {
+					sendMessage("Error: "+err.Error(), addr, conn)
+					break
+				}
+				if err := savePlayerPokemon(); err != nil {
+					fmt.Println("Error saving player data:", err)
+				}
+				sendMessage(reply, addr, conn)
+			case "@items":
+				sendMessage("Held items, give one with @hold pokemonID item:\n"+engine.DescribeEffects(engine.HeldItems), addr, conn)
+			case "@profile":
+				name := senderName
+				if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+					name = strings.TrimSpace(parts[1])
+				}
+				sendMessage(profile(name), addr, conn)
+			case "@leaderboard":
+				page := 1
+				if len(parts) == 2 {
+					if n, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && n > 0 {
+						page = n
+					}
+				}
+				sendMessage(leaderboard(page), addr, conn)
+			case "@tournament":
+				if len(parts) < 2 {
+					parts = append(parts, "")
+				}
+				tournamentCommand(parts[1], senderName, conn)
+			case "@trade":
+				if len(parts) < 2 {
+					parts = append(parts, "")
+				}
+				tradeCommand(parts[1], senderName, conn)
+			case "@queue":
+				joinQueue(senderName, conn)
+			case "@unqueue":
+				if leaveQueue(senderName) {
+					sendMessage("You left the queue.", addr, conn)
+				} else {
+					sendMessage("You are not in the queue!", addr, conn)
+				}
+			case "@spectate":
+				if len(parts) < 2 {
+					sendMessage("Invalid command", addr, conn)
+					break
+				}
+				battle := findSpectatableBattle(strings.TrimSpace(parts[1]))
+				if battle == nil {
+					sendMessage("Error: No running battle found, see @battles", addr, conn)
+					break
+				}
+				spectate(battle, senderName, conn)
+			case "@unspectate":
+				unspectate(senderName, conn)
+				sendMessage("You stopped spectating.", addr, conn)
+			case "@spec":
+				if len(parts) < 2 {
+					sendMessage("Invalid command", addr, conn)
+					break
+				}
+				spectatorChat(parts[1], senderName, conn)
 			default:
 				sendMessage("Invalid command, not in a battle!", addr, conn)
 			}
@@ -355,10 +618,13 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 			case "@all":
 				sendMessage("Cannot chat all in the battle!\nSend your next action:", addr, conn)
 			case "@quit":
+				unspectate(senderName, conn)
+				leaveQueue(senderName)
+				leaveBattles(senderName, conn)
+				cancelTrade(senderName, conn)
 				delete(players, senderName)
 				fmt.Printf("User '%s' left\n", senderName)
 				sendMessage("Goodbye '"+senderName+"'!", addr, conn)
-				// surrentder()
 			case "@private":
 				if len(parts) < 2 {
 					sendMessage("Invalid command", addr, conn)
@@ -368,13 +634,19 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 				temp := parts[1]
 				nextPart := strings.SplitN(temp, " ", 2)
 				receiver := nextPart[0]
-				if receiver != inBattleWith[senderName] {
+				if _, ok := gameStates[players[senderName].battleID].Players[receiver]; !ok || receiver == senderName {
 					sendMessage("Cannot chat with other players!", addr, conn)
 					break
 				} else {
 					privateMessage := senderName + " (private): " + nextPart[1]
 					sendMessage(privateMessage, players[receiver].Addr, conn)
 				}
+			case "@team":
+				if len(parts) < 2 {
+					sendMessage("Invalid command", addr, conn)
+					break
+				}
+				teamChat(parts[1], senderName, conn)
 			case "@battle":
 				sendMessage("You are already in a battle!", addr, conn)
 				break
@@ -391,10 +663,12 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 				nextPart := strings.SplitN(temp, " ", 2)
 				opponent := nextPart[0]
 
-				if players[senderName].battleRequestReceives[opponent] == senderName &&
+				if checkExistedPlayer(opponent) &&
+					players[senderName].battleRequestReceives[opponent] == senderName &&
 					players[opponent].battleRequestSends[senderName] == opponent {
 					delete(players[opponent].battleRequestSends, senderName)
 					delete(players[senderName].battleRequestReceives, opponent)
+					abortBattle(findBattleRequest(opponent, senderName), conn)
 
 					sendMessage("You denied a battle with player '"+opponent+"'", addr, conn)
 					sendMessage("Your battle request to player '"+senderName+"' was dinied!", players[opponent].Addr, conn)
@@ -402,150 +676,69 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 					sendMessage("Invalid acception! (WRONG opppent name or NOT RECEIVES battle request from this opponent)", addr, conn)
 				}
 			case "@pick":
-				parts = strings.Split(message, " ")
-				if len(parts) != 4 {
-					fmt.Println(len(parts))
-					sendMessage("Invalid pokemons selection!", addr, conn)
-					break
-				} else if parts[1] == parts[2] || parts[1] == parts[3] || parts[2] == parts[3] {
-					fmt.Println(len(parts))
-					sendMessage("Invalid pokemons selection!", addr, conn)
-					break
-				}
-				gameStates[players[senderName].battleID].PokemonCounter[senderName] = 0
-				if _, exists := gameStates[players[senderName].battleID].Players[senderName]; exists &&
-					gameStates[players[senderName].battleID].Status == "waiting" {
-
-					for i := 1; i < 4; i++ {
-						chosen := parts[i] // choose: Pokemon picked
-						p := findPlayerPokemonByPokeID(senderName, chosen)
-						if p != nil {
-							gameStates[players[senderName].battleID].ActivePokemons[senderName+"_"+p.ID] = &BattlePokemon{
-								Name:        p.Name,
-								ID:          p.ID,
-								Level:       p.Level,
-								Exp:         p.Exp,
-								Hp:          p.Hp,
-								Types:       p.Types,
-								Atk:         p.Atk,
-								Def:         p.Def,
-								SpAtk:       p.SpAtk,
-								SpDef:       p.SpDef,
-								Speed:       p.Speed,
-								TypeDefense: p.TypeDefense}
-							gameStates[players[senderName].battleID].PokemonCounter[senderName] += 1
-						} else {
-							sendMessage("Invalid pokemons selection!", addr, conn)
+				id := players[senderName].battleID
+				if _, exists := gameStates[id].Players[senderName]; exists &&
+					gameStates[id].Status == BattlePicking {
+
+					if _, picked := gameStates[id].Picks[senderName]; picked {
+						sendMessage("You already picked your pokemons!", addr, conn)
+						break
+					}
+					if gameStates[id].draft != nil {
+						if len(parts) < 2 {
+							sendMessage("Invalid command", addr, conn)
 							break
 						}
+						draftPick(gameStates[id], senderName, strings.TrimSpace(parts[1]), conn)
+						break
 					}
 
-					if len(gameStates[players[senderName].battleID].ActivePokemons) == 6 { // Both players have chosen their Pokémon
-						gameStates[players[senderName].battleID].Status = "active"
-						sendMessage("@pokemon_start_battle", addr, conn)
-						sendMessage("@pokemon_start_battle", players[inBattleWith[senderName]].Addr, conn)
-
-						id := players[senderName].battleID
-						var firstPokemonOpponent = gameStates[id].ActivePokemons[inBattleWith[senderName]+"_"+findPlayerPokemonByPokeID(inBattleWith[senderName], parts[1]).ID]
-						var firstPokemonSenderName = gameStates[id].ActivePokemons[senderName+"_"+findPlayerPokemonByPokeID(senderName, parts[1]).ID]
-
-						gameStates[id].BeatingPokemon[senderName] = firstPokemonSenderName             // set pokemon đang đấm nhau hiện tại
-						gameStates[id].BeatingPokemon[inBattleWith[senderName]] = firstPokemonOpponent // set pokemon đang đấm nhau hiện tại
-
-						if firstPokemonOpponent.Speed > firstPokemonSenderName.Speed {
-							gameStates[id].CurrentTurn = inBattleWith[senderName]
-						} else if firstPokemonOpponent.Speed < firstPokemonSenderName.Speed {
-							gameStates[id].CurrentTurn = senderName
-						}
+					picks, err := gameStates[id].Rules.pickTeam(senderName, strings.Fields(message)[1:])
+					if err != nil {
+						sendMessage("Invalid pokemons selection: "+err.Error(), addr, conn)
+						break
+					}
+					gameStates[id].Picks[senderName] = picks
 
-						if gameStates[id].CurrentTurn == senderName {
-							sendMessage("You attack first!", addr, conn)
-							msg := fmt.Sprintf("Active Pokemon: %s (HP: %d)", gameStates[id].BeatingPokemon[inBattleWith[senderName]].Name, gameStates[id].BeatingPokemon[inBattleWith[senderName]].Hp)
-							sendMessage(msg, players[inBattleWith[senderName]].Addr, conn)
-							sendMessage("Opponent will attack first!", players[inBattleWith[senderName]].Addr, conn)
-							msg = fmt.Sprintf("Active Pokemon: %s (HP: %d)", gameStates[id].BeatingPokemon[senderName].Name, gameStates[id].BeatingPokemon[senderName].Hp)
-							sendMessage(msg, players[senderName].Addr, conn)
-						} else {
-							sendMessage("You attack first!", players[inBattleWith[senderName]].Addr, conn)
-							sendMessage("Opponent will attack first!", addr, conn)
-						}
+					if len(gameStates[id].Picks) == len(gameStates[id].Players) { // Every player has chosen their Pokémon
+						startFight(gameStates[id], conn)
 					} else {
 						sendMessage("@pokemon_picked", addr, conn)
 					}
 				} else {
-					fmt.Println()
 					sendMessage("No active game found", addr, conn)
 				}
-			case "@attack":
-				id := players[senderName].battleID
-				if gameStates[id].CurrentTurn != senderName {
-					sendMessage("Not your turn!", addr, conn)
+			case "@ban":
+				battle := gameStates[players[senderName].battleID]
+				if battle.draft == nil || battle.Status != BattlePicking {
+					sendMessage("There is nothing to ban in this battle!", addr, conn)
 					break
 				}
-				opponent := inBattleWith[senderName]
-				currPoke := findPlayerPokemonByPokeID(opponent, gameStates[id].BeatingPokemon[opponent].ID)
-
-				dmg := int(getDmgNumber(gameStates[id].BeatingPokemon[senderName], gameStates[id].BeatingPokemon[opponent]))
-				// gameStates[id].BeatingPokemon[opponent].Hp -= dmg
-				gameStates[id].ActivePokemons[opponent+"_"+currPoke.ID].Hp -= dmg
-
-				msg := fmt.Sprintf("%s hits: %d damages!", gameStates[id].BeatingPokemon[senderName].Name, dmg)
-				sendMessage(msg, addr, conn)
-				msg = fmt.Sprintf("%s hited: %d damages!", gameStates[id].BeatingPokemon[opponent].Name, dmg)
-				sendMessage(msg, players[opponent].Addr, conn)
-
-				msg = fmt.Sprintf("Active Pokemon: %s (HP: %d)", gameStates[id].BeatingPokemon[opponent].Name, gameStates[id].BeatingPokemon[opponent].Hp)
-				sendMessage(msg, players[opponent].Addr, conn)
-				msg = fmt.Sprintf("Active Pokemon: %s (HP: %d)", gameStates[id].BeatingPokemon[senderName].Name, gameStates[id].BeatingPokemon[senderName].Hp)
-				sendMessage(msg, addr, conn)
-
-				gameStates[id].CurrentTurn = opponent
-
-				if gameStates[id].BeatingPokemon[opponent].Hp <= 0 {
-					sendMessage("Your pokemon died, change the order!", players[opponent].Addr, conn)
-					sendMessage("@pokemon_died", players[opponent].Addr, conn)
-					delete(gameStates[id].ActivePokemons, opponent+"_"+gameStates[id].BeatingPokemon[opponent].ID)
-					delete(gameStates[id].BeatingPokemon, opponent)
-					gameStates[id].PokemonCounter[opponent] -= 1
-				}
-
-				if gameStates[id].PokemonCounter[opponent] > 0 {
-					sendMessage("@opponent_attacked", players[opponent].Addr, conn)
-					sendMessage("@you_acttacked", addr, conn)
-				} else {
-					sendMessage("@win", addr, conn)
-					sendMessage("@lose", players[opponent].Addr, conn)
-					delete(inBattleWith, opponent)
-					delete(inBattleWith, senderName)
-				}
-			case "@change":
-				parts := strings.Split(message, " ")
 				if len(parts) < 2 {
-					sendMessage("Invalid pokemon name", addr, conn)
+					sendMessage("Invalid command", addr, conn)
 					break
 				}
+				draftBan(battle, senderName, strings.TrimSpace(parts[1]), conn)
+			case "@bag":
+				sendMessage(bag(senderName), addr, conn)
+			case "@attack", "@change", "@move", "@use":
 				id := players[senderName].battleID
-				if gameStates[id].CurrentTurn != senderName {
-					sendMessage("Not your turn!", addr, conn)
+				if gameStates[id].Status != BattleActive {
+					sendMessage("The battle has not started yet!", addr, conn)
 					break
 				}
-
-				opponent := inBattleWith[senderName]
-				pokemonKey := senderName + "_" + parts[1]
-
-				if activePokemon, exists := gameStates[id].ActivePokemons[pokemonKey]; exists {
-					gameStates[id].BeatingPokemon[senderName] = activePokemon
-					sendMessage("@changed", addr, conn)
-					gameStates[id].CurrentTurn = opponent
-				} else {
-					sendMessage("Invalid Pokemon", addr, conn)
+				action, err := engine.ParseAction(senderName, message)
+				if err != nil {
+					sendMessage("Invalid pokemon name", addr, conn)
+					break
 				}
+				playAction(gameStates[id], action, conn)
 			case "@y":
 				playerPokemons := findPlayerPokemonByPlayer(senderName)
 				fmt.Printf("Pokémons of player %s:\n", senderName)
 				var str string
 				for _, pokemon := range playerPokemons {
-					str += fmt.Sprintf("Pokemon ID: %s, Name: %s, Level: %d, HP: %d\n", pokemon.ID, pokemon.Name, pokemon.Level, pokemon.Hp)
+					str += describeListed(pokemon) + "\n"
 
 				}
 				sendMessage("@list_then_pick_pokemon"+str, addr, conn)
@@ -560,12 +753,41 @@ func handleMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
 	}
 }
 
+// savePlayerPokemon writes the player store back to its file
+func savePlayerPokemon() error {
+	data, err := json.MarshalIndent(playersPokemons, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(playerpokemonsData, data, 0644)
+}
+
+// findPlayerRecord returns the store entry of a player, a new one if the
+// player is not in the store yet
+func findPlayerRecord(playerName string) *PlayerPokemon {
+	for i := range playersPokemons {
+		if playersPokemons[i].Owner == playerName {
+			return &playersPokemons[i]
+		}
+	}
+	playersPokemons = append(playersPokemons, PlayerPokemon{Owner: playerName})
+	return &playersPokemons[len(playersPokemons)-1]
+}
+
 func loadPokedex(filename string) error {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &pokedex) // gán data vào pokedex
+	if err := json.Unmarshal(data, &pokedex); err != nil { // gán data vào pokedex
+		return err
+	}
+	for i := range pokedex {
+		if len(pokedex[i].Abilities) == 0 {
+			pokedex[i].Abilities = speciesAbilities[pokedex[i].Name]
+		}
+	}
+	return nil
 }
 
 // load data in playersPokemon.json
@@ -594,6 +816,24 @@ func findPokemonByNameOrID(name string) *Pokemon {
 	return nil
 }
 
+// battlePokemonFromPokedex makes a battle pokemon with the base stats of a species
+func battlePokemonFromPokedex(p *Pokemon, id string) engine.BattlePokemon {
+	return engine.BattlePokemon{
+		Name:        p.Name,
+		ID:          id,
+		Level:       1,
+		Types:       p.Types,
+		Hp:          p.PokeInfo.Hp,
+		Atk:         p.PokeInfo.Atk,
+		Def:         p.PokeInfo.Def,
+		SpAtk:       p.PokeInfo.SpAtk,
+		SpDef:       p.PokeInfo.SpDef,
+		Speed:       p.PokeInfo.Speed,
+		TypeDefense: p.PokeInfo.TypeDefense,
+		Ability:     defaultAbility(p),
+	}
+}
+
 func findPlayerPokemonByPlayer(playerName string) []PlayerPokeInfo {
 	var pokemon []PlayerPokeInfo
 	for _, p := range playersPokemons {
@@ -617,19 +857,50 @@ func findPlayerPokemonByPokeID(playerName string, idPoke string) *PlayerPokeInfo
 	return nil
 }
 
+// ownedPokemon gives the store entry of one of a player's pokemons, to
+// change it
+func ownedPokemon(playerName string, idPoke string) *PlayerPokeInfo {
+	for i := range playersPokemons {
+		if playersPokemons[i].Owner != playerName {
+			continue
+		}
+		for j := range playersPokemons[i].PlayerPokeInfo {
+			if playersPokemons[i].PlayerPokeInfo[j].ID == idPoke {
+				return &playersPokemons[i].PlayerPokeInfo[j]
+			}
+		}
+	}
+	return nil
+}
+
 func pokedexScanner(pokeName string) string {
 	pokemon := findPokemonByNameOrID(pokeName)
 	if pokemon == nil {
 		return fmt.Sprintf("Pokémon with name %s not found", pokeName)
 	}
 	return fmt.Sprintf("ID: %s\nName: %s\nTypes: [%s]\nBase Stats: HP: %d, ATK: %d, DEF: %d, Sp.Atk: %d, Sp.Def: %d, Speed: %d",
-		pokemon.Id, pokemon.Name, pokemon.PokeInfo.Types[:], pokemon.PokeInfo.Hp, pokemon.PokeInfo.Atk, pokemon.PokeInfo.Def,
-		pokemon.PokeInfo.SpAtk, pokemon.PokeInfo.SpDef, pokemon.PokeInfo.Speed)
+		pokemon.Id, pokemon.Name, strings.Join(pokemon.Types, ", "), pokemon.PokeInfo.Hp, pokemon.PokeInfo.Atk, pokemon.PokeInfo.Def,
+		pokemon.PokeInfo.SpAtk, pokemon.PokeInfo.SpDef, pokemon.PokeInfo.Speed) + describeAbilities(pokemon)
+}
+
+// describeAbilities lists the abilities of a species and what they do
+func describeAbilities(species *Pokemon) string {
+	if len(species.Abilities) == 0 {
+		return ""
+	}
+	var list []string
+	for _, name := range species.Abilities {
+		if ability := engine.FindAbility(name); ability != nil {
+			name += " (" + ability.Description + ")"
+		}
+		list = append(list, name)
+	}
+	return "\nAbilities: " + strings.Join(list, ", ")
 }
 
 func isInBattle(p string) bool {
-	_, exists := inBattleWith[p]
-	if !exists {
+	player, exists := players[p]
+	if !exists || player.battleID == 0 {
 		return false
 	} else {
 		return true
@@ -638,7 +909,7 @@ func isInBattle(p string) bool {
 
 func broadcastMessage(message string, senderName string, conn *net.UDPConn) {
 	for username, player := range players {
-		if username != senderName {
+		if username != senderName && player.Addr != nil {
 			fullMessage := senderName + " (public): " + message // Include sender's name
 			_, err := conn.WriteToUDP([]byte(fullMessage), player.Addr)
 			if err != nil {
@@ -649,6 +920,9 @@ func broadcastMessage(message string, senderName string, conn *net.UDPConn) {
 }
 
 func sendMessage(message string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if addr == nil { // player already left
+		return
+	}
 	_, err := conn.WriteToUDP([]byte(message), addr)
 	if err != nil {
 		fmt.Println("Error sending message:", err)
@@ -666,7 +940,7 @@ func checkExistedPlayer(username string) bool {
 
 func getPlayernameByAddr(addr *net.UDPAddr) string {
 	for _, player := range players {
-		if player.Addr.IP.Equal(addr.IP) && player.Addr.Port == addr.Port {
+		if player.Addr != nil && player.Addr.IP.Equal(addr.IP) && player.Addr.Port == addr.Port {
 			return player.Name
 		}
 	}
@@ -675,63 +949,14 @@ func getPlayernameByAddr(addr *net.UDPAddr) string {
 
 func checkExistedPlayerByAddr(addr *net.UDPAddr) bool {
 	for _, player := range players {
-		if player.Addr.IP.Equal(addr.IP) && player.Addr.Port == addr.Port {
+		if player.Addr != nil && player.Addr.IP.Equal(addr.IP) && player.Addr.Port == addr.Port {
 			return true
 		}
 	}
 	return false
 }
 
-func getDmgNumber(pAtk *BattlePokemon, pRecive *BattlePokemon) int {
-	var dmg float32
-	var types = make(map[string]float32)
-
-	types["Normal"] = pRecive.TypeDefense.Normal
-	types["Fire"] = pRecive.TypeDefense.Fire
-	types["Water"] = pRecive.TypeDefense.Water
-	types["Electric"] = pRecive.TypeDefense.Electric
-	types["Grass"] = pRecive.TypeDefense.Grass
-	types["Ice"] = pRecive.TypeDefense.Ice
-	types["Fighting"] = pRecive.TypeDefense.Fighting
-	types["Poison"] = pRecive.TypeDefense.Poison
-	types["Ground"] = pRecive.TypeDefense.Ground
-	types["Flying"] = pRecive.TypeDefense.Flying
-	types["Psychic"] = pRecive.TypeDefense.Psychic
-	types["Bug"] = pRecive.TypeDefense.Bug
-	types["Rock"] = pRecive.TypeDefense.Rock
-	types["Ghost"] = pRecive.TypeDefense.Ghost
-	types["Dragon"] = pRecive.TypeDefense.Dragon
-	types["Dark"] = pRecive.TypeDefense.Dark
-	types["Steel"] = pRecive.TypeDefense.Steel
-	types["Fairy"] = pRecive.TypeDefense.Fairy
-
-	rand.Seed(time.Now().UnixNano())
-	choseAtk := rand.Intn(2)
-	if choseAtk == 0 {
-		dmg = float32(pAtk.Atk) - float32(pRecive.Def)
-		if dmg < 0 {
-			dmg = 0
-		}
-		return int(dmg)
-	} else {
-		var typeDefense float32 = 0.0
-		for _, pAtkTypes := range pAtk.Types {
-			for typeDef, def := range types {
-				if typeDef == pAtkTypes {
-					if typeDefense < def {
-						typeDefense = def
-					}
-				}
-			}
-		}
-		dmg = float32(pAtk.SpAtk)*typeDefense - float32(pRecive.SpDef)
-		if dmg < 0 {
-			dmg = 0
-		}
-		return int(dmg)
-	}
-}
-func checkSpeed(pAtk *BattlePokemon, pRecive *BattlePokemon) string {
+func checkSpeed(pAtk *engine.BattlePokemon, pRecive *engine.BattlePokemon) string {
 	if pAtk.Speed > pRecive.Speed {
 		return "player"
 	} else if pAtk.Speed > pRecive.Speed {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useStore runs a test in a temporary directory holding a copy of the player
+// store, so saving does not touch the real one
+func useStore(t *testing.T, store string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(playerpokemonsData), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(playerpokemonsData, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := playersPokemons
+	t.Cleanup(func() { playersPokemons = saved })
+	playersPokemons = nil
+	if err := loadPlayerPokemon(playerpokemonsData); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlayerStoreRoundTrip(t *testing.T) {
+	original, err := ioutil.ReadFile("playersPokemon.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useStore(t, "playersPokemon.json")
+	if err := savePlayerPokemon(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(playerpokemonsData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(saved, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("saving the store changed it:\n%s", saved)
+	}
+	for _, record := range playersPokemons {
+		for _, p := range record.PlayerPokeInfo {
+			if p.SpAtk == 0 || p.SpDef == 0 {
+				t.Errorf("%s's %s %s has no Sp.Atk or Sp.Def", record.Owner, p.ID, p.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"./engine"
+)
+
+// Team battles and free-for-all have more than two players. The challenger
+// invites everybody at once and the battle starts when all of them accepted:
+//
+//	@battle bob carol dave team   alice and bob against carol and dave
+//	@battle bob carol ffa         alice, bob and carol against each other
+
+// requestMultiBattle sends a battle request from sender to every invited player
+func requestMultiBattle(sender string, invited []string, format engine.BattleFormat, rules Ruleset, conn *net.UDPConn) {
+	addr := players[sender].Addr
+	if format == engine.TeamBattle && len(invited) != 3 {
+		sendMessage("Error: A team battle needs 3 other players: @battle teammate opponent1 opponent2 team", addr, conn)
+		return
+	}
+	if format == engine.FreeForAll && (len(invited) < 2 || len(invited) > 3) {
+		sendMessage("Error: A free-for-all needs 2 or 3 other players: @battle player1 player2 [player3] ffa", addr, conn)
+		return
+	}
+	seen := map[string]bool{sender: true}
+	for _, name := range invited {
+		switch {
+		case seen[name]:
+			sendMessage("Error: Every player can only be invited once!", addr, conn)
+			return
+		case !checkExistedPlayer(name):
+			sendMessage("Error: Player '"+name+"' did not exist in the server!", addr, conn)
+			return
+		case isInBattle(name):
+			sendMessage("Error: Player '"+name+"' is already in a battle!", addr, conn)
+			return
+		case findBattleRequest(sender, name) != nil:
+			sendMessage("You already sent a battle request to '"+name+"'!", addr, conn)
+			return
+		}
+		seen[name] = true
+	}
+
+	battle := newBattle(sender, invited...)
+	battle.Format = format
+	battle.Rules = rules
+	battle.Accepted = map[string]bool{sender: true}
+	description := "free-for-all pokemon battle (" + strings.Join(battlePlayerNames(battle), ", ") + ")"
+	if format == engine.TeamBattle {
+		battle.Sides = make(map[string]string)
+		var sides []string
+		for _, team := range [][]string{{sender, invited[0]}, {invited[1], invited[2]}} {
+			sort.Strings(team)
+			side := strings.Join(team, "+")
+			for _, name := range team {
+				battle.Sides[name] = side
+			}
+			sides = append(sides, side)
+		}
+		description = "team pokemon battle (" + strings.Join(sides, " vs ") + ")"
+	}
+	if rules.Name != defaultRuleset {
+		description += " with " + rules.Name + " rules"
+	}
+	for _, name := range invited {
+		players[sender].battleRequestSends[name] = sender
+		players[name].battleRequestReceives[sender] = name
+		sendMessage("Player '"+sender+"' requests you a "+description+"!", players[name].Addr, conn)
+	}
+	sendMessage("You requested a "+description+", waiting for every player to accept...", addr, conn)
+}
+
+// acceptMultiBattle records that sender accepted, and moves the battle to the
+// picking phase once everybody did
+func acceptMultiBattle(battle *Battle, sender string, conn *net.UDPConn) {
+	battle.Accepted[sender] = true
+	var pending []string
+	for _, name := range battlePlayerNames(battle) {
+		if !battle.Accepted[name] {
+			pending = append(pending, name)
+		}
+	}
+	if len(pending) > 0 {
+		for name := range battle.Accepted {
+			sendMessage(fmt.Sprintf("%s accepted the battle, waiting for %s...", sender, strings.Join(pending, ", ")), playerAddr(name), conn)
+		}
+		return
+	}
+
+	for name := range battle.Players {
+		if isInBattle(name) {
+			for other := range battle.Players {
+				sendMessage("Error: Player '"+name+"' is already in a battle!", playerAddr(other), conn)
+			}
+			abortBattle(battle, conn)
+			return
+		}
+	}
+	if err := acceptBattle(battle, conn); err != nil {
+		sendMessage("Error: "+err.Error(), playerAddr(sender), conn)
+		return
+	}
+	for name := range battle.Players {
+		sendMessage("Every player accepted the battle!", playerAddr(name), conn)
+		sendMessage("@accepted_battle", playerAddr(name), conn)
+	}
+	startPickTimer(battle.battleID, conn)
+}
+
+// finishMultiBattle ends a team battle or free-for-all won by a side and
+// archives it
+func finishMultiBattle(battle *Battle, side string, result string, conn *net.UDPConn) {
+	if err := battle.transition(BattleFinished); err != nil {
+		fmt.Println("Error finishing battle:", err)
+		return
+	}
+	var winners, losers []string
+	for _, name := range battle.State.Players {
+		if battle.State.Sides[name] == side {
+			winners = append(winners, name)
+			sendMessage("@win", playerAddr(name), conn)
+		} else {
+			losers = append(losers, name)
+			sendMessage("@lose", playerAddr(name), conn)
+		}
+	}
+	message := fmt.Sprintf("%s won the free-for-all against %s (%s) after %d turns!", side, strings.Join(losers, ", "), result, battle.State.Turn)
+	if battle.Format == engine.TeamBattle {
+		message = fmt.Sprintf("%s won the team battle against %s (%s) after %d turns!", side, strings.Join(losers, "+"), result, battle.State.Turn)
+	}
+	for _, name := range battle.State.Players {
+		sendMessage(message, playerAddr(name), conn)
+	}
+	announce(battle, message, conn)
+
+	replayFile, err := saveReplay(battle, side, result)
+	if err != nil {
+		fmt.Println("Error saving replay:", err)
+	}
+	err = archiveBattle(BattleRecord{
+		ID:        battle.battleID,
+		Players:   battle.State.Players,
+		Teams:     pickedTeams(battle),
+		Winner:    side,
+		Loser:     strings.Join(losers, "+"),
+		Result:    result,
+		Turns:     battle.State.Turn,
+		StartedAt: battle.StartedAt,
+		EndedAt:   time.Now(),
+		Replay:    replayFile,
+	})
+	if err != nil {
+		fmt.Println("Error archiving battle:", err)
+	}
+	carryInjuries(battle)
+	spendHeldItems(battle)
+	payRewards(battle, winners, losers, result, conn)
+	recordTeamResult(battle, winners, losers, result)
+	cleanupBattle(battle)
+}
+
+// teamChat sends a message to the teammates of sender in a team battle
+func teamChat(message string, sender string, conn *net.UDPConn) {
+	battle := gameStates[players[sender].battleID]
+	if battle == nil || battle.Format != engine.TeamBattle {
+		sendMessage("You have no teammate in this battle!", players[sender].Addr, conn)
+		return
+	}
+	for name, side := range battle.Sides {
+		if name != sender && side == battle.Sides[sender] {
+			sendMessage(sender+" (team): "+message, playerAddr(name), conn)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const leaderboardPageSize = 10
+
+type (
+	PlayerStats struct { // results of a player, kept in the player store
+		Wins          int            `json:"Wins"`
+		Losses        int            `json:"Losses"`
+		Forfeits      int            `json:"Forfeits"`      // losses by forfeit or timeout, also counted in Losses
+		Streak        int            `json:"Streak"`        // current streak, wins if positive, losses if negative
+		BestStreak    int            `json:"BestStreak"`    // longest win streak
+		PokemonUsage  map[string]int `json:"PokemonUsage"`  // battles each species was picked in
+		RatingHistory []RatingPoint  `json:"RatingHistory"` // rating after every battle
+	}
+
+	RatingPoint struct {
+		At     time.Time `json:"At"`
+		Rating int       `json:"Rating"`
+	}
+)
+
+// recordResult updates the ratings and statistics of both players of a
+// finished battle and saves the player store. Battles against AI trainers
+// are not counted.
+func recordResult(battle *Battle, winner string, loser string, result string, conn *net.UDPConn) {
+	if isAI(winner) || isAI(loser) {
+		return
+	}
+	updateRatings(winner, loser, conn)
+	recordStats(battle, []string{winner}, []string{loser}, result)
+	for _, name := range []string{winner, loser} {
+		record := findPlayerRecord(name)
+		record.Stats.RatingHistory = append(record.Stats.RatingHistory, RatingPoint{At: time.Now(), Rating: record.Rating})
+	}
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+}
+
+// recordTeamResult updates the statistics of the players of a finished team
+// battle or free-for-all and saves the player store, ratings do not change
+func recordTeamResult(battle *Battle, winners []string, losers []string, result string) {
+	recordStats(battle, winners, losers, result)
+	if err := savePlayerPokemon(); err != nil {
+		fmt.Println("Error saving player data:", err)
+	}
+}
+
+func recordStats(battle *Battle, winners []string, losers []string, result string) {
+	won := make(map[string]bool)
+	for _, name := range winners {
+		won[name] = true
+	}
+	for _, name := range append(append([]string(nil), winners...), losers...) {
+		record := findPlayerRecord(name)
+		if record.Stats == nil {
+			record.Stats = &PlayerStats{PokemonUsage: make(map[string]int)}
+		}
+		stats := record.Stats
+		if stats.PokemonUsage == nil {
+			stats.PokemonUsage = make(map[string]int)
+		}
+		if won[name] {
+			stats.Wins++
+			if stats.Streak < 0 {
+				stats.Streak = 0
+			}
+			stats.Streak++
+			if stats.Streak > stats.BestStreak {
+				stats.BestStreak = stats.Streak
+			}
+		} else {
+			stats.Losses++
+			if result != "knockout" {
+				stats.Forfeits++
+			}
+			if stats.Streak > 0 {
+				stats.Streak = 0
+			}
+			stats.Streak--
+		}
+		for _, p := range battle.Picks[name] {
+			stats.PokemonUsage[p.Name]++
+		}
+	}
+}
+
+// mostUsedPokemon gives the species picked the most, by name on a tie
+func mostUsedPokemon(stats *PlayerStats) (string, int) {
+	best, count := "", 0
+	for name, n := range stats.PokemonUsage {
+		if n > count || (n == count && name < best) {
+			best, count = name, n
+		}
+	}
+	return best, count
+}
+
+// profile describes the statistics of a player
+func profile(name string) string {
+	var record *PlayerPokemon
+	for i := range playersPokemons {
+		if playersPokemons[i].Owner == name {
+			record = &playersPokemons[i]
+		}
+	}
+	if record == nil {
+		return "Error: Player '" + name + "' not found!"
+	}
+	stats := record.Stats
+	if stats == nil {
+		return fmt.Sprintf("Profile of %s\nRating: %d\nCoins: %d\nNo battle played yet.", name, rating(name), record.Coins)
+	}
+
+	lines := []string{
+		"Profile of " + name,
+		fmt.Sprintf("Rating: %d", rating(name)),
+		fmt.Sprintf("Coins: %d", record.Coins),
+		fmt.Sprintf("Battles: %d (Wins: %d, Losses: %d, Forfeits: %d)", stats.Wins+stats.Losses, stats.Wins, stats.Losses, stats.Forfeits),
+	}
+	if stats.Streak >= 0 {
+		lines = append(lines, fmt.Sprintf("Streak: %d wins (best: %d)", stats.Streak, stats.BestStreak))
+	} else {
+		lines = append(lines, fmt.Sprintf("Streak: %d losses (best: %d wins)", -stats.Streak, stats.BestStreak))
+	}
+	if pokemon, count := mostUsedPokemon(stats); count > 0 {
+		lines = append(lines, fmt.Sprintf("Most used pokemon: %s (%d battles)", pokemon, count))
+	}
+	var history []string
+	from := 0
+	if len(stats.RatingHistory) > 10 {
+		from = len(stats.RatingHistory) - 10
+	}
+	for _, point := range stats.RatingHistory[from:] {
+		history = append(history, fmt.Sprint(point.Rating))
+	}
+	lines = append(lines, "Rating history: "+strings.Join(history, " -> "))
+	return strings.Join(lines, "\n")
+}
+
+// leaderboard lists one page of the players who battled, best rating first
+func leaderboard(page int) string {
+	var ranked []*PlayerPokemon
+	for i := range playersPokemons {
+		if playersPokemons[i].Stats != nil {
+			ranked = append(ranked, &playersPokemons[i])
+		}
+	}
+	if len(ranked) == 0 {
+		return "No battle played yet."
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Rating != ranked[j].Rating {
+			return ranked[i].Rating > ranked[j].Rating
+		}
+		return ranked[i].Owner < ranked[j].Owner
+	})
+
+	pages := (len(ranked) + leaderboardPageSize - 1) / leaderboardPageSize
+	if page > pages {
+		return fmt.Sprintf("Error: The leaderboard only has %d pages!", pages)
+	}
+	lines := []string{fmt.Sprintf("Leaderboard (page %d/%d)", page, pages)}
+	for i := (page - 1) * leaderboardPageSize; i < len(ranked) && i < page*leaderboardPageSize; i++ {
+		stats := ranked[i].Stats
+		lines = append(lines, fmt.Sprintf("%d. %s - %d (W %d / L %d)", i+1, ranked[i].Owner, rating(ranked[i].Owner), stats.Wins, stats.Losses))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	"./engine"
+)
+
+// Random battles skip the pick phase: the server gives every player a team
+// of pokemons from the pokedex, so players who own no pokemon can battle
+// too. Weak species get higher levels than strong ones, from level 100 for a
+// base stat total of 200 down to level 50 from 600 on, and a team never has
+// two pokemons that share a type.
+
+const (
+	randomLevelMin = 50
+	randomLevelMax = 100
+)
+
+// randomLevel is the level of a species with the given base stat total in a
+// random battle
+func randomLevel(total int) int {
+	level := randomLevelMax - (total-200)/8
+	if level < randomLevelMin {
+		return randomLevelMin
+	}
+	if level > randomLevelMax {
+		return randomLevelMax
+	}
+	return level
+}
+
+func baseStatTotal(p *Pokemon) int {
+	info := p.PokeInfo
+	return info.Hp + info.Atk + info.Def + info.SpAtk + info.SpDef + info.Speed
+}
+
+// randomBattleTeam builds a team of size pokemons at their random battle level
+func randomBattleTeam(rng *rand.Rand, size int) []engine.BattlePokemon {
+	var team []engine.BattlePokemon
+	types := make(map[string]bool)
+	for _, i := range rng.Perm(len(pokedex)) {
+		if len(team) == size {
+			break
+		}
+		species := &pokedex[i]
+		if species.PokeInfo.Hp == 0 {
+			continue
+		}
+		shared := false
+		for _, t := range species.Types {
+			shared = shared || types[t]
+		}
+		if shared {
+			continue
+		}
+		for _, t := range species.Types {
+			types[t] = true
+		}
+		p := battlePokemonFromPokedex(species, fmt.Sprintf("#%03d", len(team)+1))
+		team = append(team, atLevel(p, randomLevel(baseStatTotal(species))))
+	}
+	return team
+}
+
+// startRandomBattle gives every player of an accepted battle a random team
+// and starts the fight
+func startRandomBattle(battle *Battle, conn *net.UDPConn) {
+	rng := rand.New(rand.NewSource(getNanoTime()))
+	for name := range battle.Players {
+		team := randomBattleTeam(rng, battle.Rules.TeamSize)
+		battle.Picks[name] = team
+		var pokemons []string
+		for _, p := range team {
+			pokemons = append(pokemons, fmt.Sprintf("%s %s (Lv. %d, HP: %d)", p.ID, p.Name, p.Level, p.Hp))
+		}
+		sendMessage("Your random team: "+strings.Join(pokemons, ", "), playerAddr(name), conn)
+	}
+	startFight(battle, conn)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRating = 1500
+	ratingK       = 32 // how much a single battle can move a rating
+)
+
+var (
+	matchWindow     = flag.Int("match-window", 100, "rating difference allowed between two queued players")
+	matchWindowStep = flag.Int("match-window-step", 50, "how much the rating window grows every match-window-every")
+	matchWindowWait = flag.Duration("match-window-every", 10*time.Second, "how often the rating window of a waiting player grows")
+)
+
+var matchQueue = make(map[string]time.Time) // queued players and when they joined the queue
+
+// rating gives the ladder rating of a player, defaultRating before their first battle
+func rating(name string) int {
+	for _, record := range playersPokemons {
+		if record.Owner == name && record.Rating != 0 {
+			return record.Rating
+		}
+	}
+	return defaultRating
+}
+
+// eloChange is how many points the winner takes from the loser
+func eloChange(winner int, loser int) int {
+	expected := 1 / (1 + math.Pow(10, float64(loser-winner)/400))
+	change := int(math.Round(ratingK * (1 - expected)))
+	if change < 1 {
+		change = 1
+	}
+	return change
+}
+
+// updateRatings moves points from the loser to the winner of a battle
+func updateRatings(winner string, loser string, conn *net.UDPConn) {
+	winnerRating, loserRating := rating(winner), rating(loser)
+	change := eloChange(winnerRating, loserRating)
+	findPlayerRecord(winner).Rating = winnerRating + change
+	findPlayerRecord(loser).Rating = loserRating - change
+	sendMessage(fmt.Sprintf("Rating: %d (+%d)", winnerRating+change, change), playerAddr(winner), conn)
+	sendMessage(fmt.Sprintf("Rating: %d (-%d)", loserRating-change, change), playerAddr(loser), conn)
+}
+
+func joinQueue(name string, conn *net.UDPConn) {
+	addr := players[name].Addr
+	if _, ok := matchQueue[name]; ok {
+		sendMessage("You are already in the queue!", addr, conn)
+		return
+	}
+	if len(findPlayerPokemonByPlayer(name)) < 3 {
+		sendMessage("Error: You need at least 3 pokemons to battle!", addr, conn)
+		return
+	}
+	matchQueue[name] = time.Now()
+	sendMessage(fmt.Sprintf("You joined the queue with rating %d, waiting for an opponent... (@unqueue to leave)", rating(name)), addr, conn)
+}
+
+func leaveQueue(name string) bool {
+	_, ok := matchQueue[name]
+	delete(matchQueue, name)
+	return ok
+}
+
+// matchWindowOf is the rating difference a player accepts after waiting since joined
+func matchWindowOf(joined time.Time, now time.Time) int {
+	steps := 0
+	if *matchWindowWait > 0 {
+		steps = int(now.Sub(joined) / *matchWindowWait)
+	}
+	return *matchWindow + steps**matchWindowStep
+}
+
+// matchPlayers pairs queued players whose ratings are close enough, the
+// players waiting the longest first, and starts their battles
+func matchPlayers(conn *net.UDPConn) {
+	now := time.Now()
+	var queued []string
+	for name := range matchQueue {
+		if players[name] == nil || isInBattle(name) {
+			delete(matchQueue, name)
+			continue
+		}
+		queued = append(queued, name)
+	}
+	sort.Slice(queued, func(i, j int) bool { return matchQueue[queued[i]].Before(matchQueue[queued[j]]) })
+
+	matched := make(map[string]bool)
+	for i, name := range queued {
+		if matched[name] {
+			continue
+		}
+		for _, other := range queued[i+1:] {
+			if matched[other] {
+				continue
+			}
+			diff := rating(name) - rating(other)
+			if diff < 0 {
+				diff = -diff
+			}
+			// both players must accept the difference
+			if diff > matchWindowOf(matchQueue[name], now) || diff > matchWindowOf(matchQueue[other], now) {
+				continue
+			}
+			matched[name], matched[other] = true, true
+			startRankedBattle(name, other, conn)
+			break
+		}
+	}
+}
+
+func startRankedBattle(name string, other string, conn *net.UDPConn) {
+	battle := newBattle(name, other)
+	battle.Ranked = true
+	if err := acceptBattle(battle, conn); err != nil {
+		fmt.Println("Error starting ranked battle:", err)
+		abortBattle(battle, conn)
+		return
+	}
+	for _, pair := range [][2]string{{name, other}, {other, name}} {
+		addr := players[pair[0]].Addr
+		sendMessage(fmt.Sprintf("Match found! You battle '%s' (rating %d)", pair[1], rating(pair[1])), addr, conn)
+		sendMessage("@accepted_battle", addr, conn)
+	}
+	startPickTimer(battle.battleID, conn)
+}
+
+// runMatchmaking looks for matches in the queue every interval
+func runMatchmaking(interval time.Duration, conn *net.UDPConn) {
+	for range time.Tick(interval) {
+		mu.Lock()
+		matchPlayers(conn)
+		mu.Unlock()
+	}
+}
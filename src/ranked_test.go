@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEloChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		winner, loser int
+		want          int
+	}{
+		{"same rating", 1500, 1500, 16},
+		{"favorite wins", 1900, 1500, 3},
+		{"underdog wins", 1500, 1900, 29},
+		{"at least one point", 3000, 1000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eloChange(tt.winner, tt.loser); got != tt.want {
+				t.Errorf("eloChange(%d, %d) = %d, want %d", tt.winner, tt.loser, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRating(t *testing.T) {
+	useRecords(t, []PlayerPokemon{{Owner: "ash", Rating: 1620}, {Owner: "gary"}})
+	for name, want := range map[string]int{"ash": 1620, "gary": defaultRating, "brock": defaultRating} {
+		if got := rating(name); got != want {
+			t.Errorf("%s is rated %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestMatchWindowOf(t *testing.T) {
+	joined := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		waited time.Duration
+		want   int
+	}{
+		{0, *matchWindow},
+		{*matchWindowWait - time.Second, *matchWindow},
+		{*matchWindowWait, *matchWindow + *matchWindowStep},
+		{3 * *matchWindowWait, *matchWindow + 3**matchWindowStep},
+	}
+	for _, tt := range tests {
+		if got := matchWindowOf(joined, joined.Add(tt.waited)); got != tt.want {
+			t.Errorf("window after %v is %d, want %d", tt.waited, got, tt.want)
+		}
+	}
+}
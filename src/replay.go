@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"./engine"
+)
+
+// A replay is a JSON file written to replayDir when a battle is finished,
+// named after the battle ID:
+//
+//	{
+//	  "Version": 2,                      // 1 for battles from before accuracy and critical hits
+//	  "Format": "singles",               // or "doubles", "team", "ffa", singles when missing
+//	  "BattleID": 1718000000000000000,
+//	  "Seed": 1718000000000000001,       // seed of the battle's damage rng
+//	  "Players": ["anh", "thien"],
+//	  "Teams": {"anh": [BattlePokemon, ...], "thien": [...]}, // in pick order, full HP
+//	  "Sides": {"anh": "anh+vi", ...},   // team battles only
+//	  "Rules": "standard",               // ruleset the teams were picked with
+//	  "FirstTurn": "thien",              // player on turn when the battle started, empty in other formats
+//	  "Log": [
+//	    {"Turn": 0, "Event": "The battle begins! ..."},
+//	    {"Turn": 0, "Player": "thien", "Action": "@attack"},
+//	    {"Turn": 1, "Event": "thien's Dewgong hits anh's Lapras: 12 damages! (HP: 58)"},
+//	    ...
+//	  ],
+//	  "Winner": "thien",                 // a side in team battles
+//	  "Result": "knockout",              // knockout, forfeit or timeout
+//	  "Turns": 14,
+//	  "FinalHp": {"anh_#001": 0, ...}    // HP of every picked pokemon at the end
+//	}
+//
+// Log entries are either an Action (a command a player sent, or that was
+// played for them on timeout) or an Event (what the server announced).
+// Replaying the actions in order from Teams and Seed gives the same battle.
+type (
+	Replay struct {
+		Version   int                               `json:"Version"`
+		Format    engine.BattleFormat               `json:"Format,omitempty"`
+		BattleID  int64                             `json:"BattleID"`
+		Seed      int64                             `json:"Seed"`
+		Players   []string                          `json:"Players"`
+		Teams     map[string][]engine.BattlePokemon `json:"Teams"`
+		Sides     map[string]string                 `json:"Sides,omitempty"`
+		Rules     string                            `json:"Rules,omitempty"`
+		FirstTurn string                            `json:"FirstTurn"`
+		Log       []ReplayEntry                     `json:"Log"`
+		Winner    string                            `json:"Winner"`
+		Result    string                            `json:"Result"`
+		Turns     int                               `json:"Turns"`
+		FinalHp   map[string]int                    `json:"FinalHp"`
+	}
+
+	ReplayEntry struct {
+		Turn   int    `json:"Turn"`
+		Player string `json:"Player,omitempty"`
+		Action string `json:"Action,omitempty"`
+		Event  string `json:"Event,omitempty"`
+	}
+)
+
+const replayVersion = 2
+
+// startReplay begins recording a battle whose pokemons were just sent out
+func startReplay(battle *Battle) {
+	battle.replay = &Replay{
+		Version:   replayVersion,
+		Format:    battle.Format,
+		BattleID:  battle.battleID,
+		Seed:      battle.Seed,
+		Players:   battle.State.Players,
+		Teams:     battle.Picks,
+		Sides:     battle.Sides,
+		Rules:     battle.Rules.Name,
+		FirstTurn: battle.State.CurrentTurn,
+	}
+}
+
+func recordAction(battle *Battle, player string, action string) {
+	if battle.replay != nil {
+		battle.replay.Log = append(battle.replay.Log, ReplayEntry{Turn: battle.State.Turn, Player: player, Action: action})
+	}
+}
+
+// announce records a battle event and shows it to the spectators
+func announce(battle *Battle, message string, conn *net.UDPConn) {
+	if battle.replay != nil {
+		battle.replay.Log = append(battle.replay.Log, ReplayEntry{Turn: battle.State.Turn, Event: message})
+	}
+	notifySpectators(battle, message, conn)
+}
+
+// saveReplay writes the replay of a finished battle and returns its path
+func saveReplay(battle *Battle, winner string, result string) (string, error) {
+	if battle.replay == nil {
+		return "", nil
+	}
+	battle.replay.Winner = winner
+	battle.replay.Result = result
+	battle.replay.Turns = battle.State.Turn
+	battle.replay.FinalHp = finalHp(battle.State)
+
+	data, err := json.MarshalIndent(battle.replay, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(replayDir, 0755); err != nil {
+		return "", err
+	}
+	filename := filepath.Join(replayDir, fmt.Sprintf("%d.json", battle.battleID))
+	return filename, ioutil.WriteFile(filename, data, 0644)
+}
+
+func loadReplay(filename string) (*Replay, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var replay Replay
+	if err := json.Unmarshal(data, &replay); err != nil {
+		return nil, err
+	}
+	if replay.Version < 1 || replay.Version > replayVersion {
+		return nil, fmt.Errorf("unsupported replay version %d", replay.Version)
+	}
+	return &replay, nil
+}
+
+// finalHp gives the HP of every picked pokemon, fainted ones have 0
+func finalHp(state engine.BattleState) map[string]int {
+	hp := make(map[string]int)
+	for name, team := range state.Teams {
+		for _, p := range team {
+			hp[name+"_"+p.ID] = p.Hp
+		}
+	}
+	return hp
+}
+
+// simulateReplay plays the recorded actions again with the recorded seed
+func simulateReplay(replay *Replay) (engine.BattleState, error) {
+	e := engine.NewEngine(replay.Seed, time.Now)
+	e.Classic = replay.Version < 2
+	var state engine.BattleState
+	switch replay.Format {
+	case engine.Doubles:
+		state, _ = e.StartDoubles(replay.Teams)
+	case engine.TeamBattle, engine.FreeForAll:
+		state, _ = e.StartMulti(replay.Format, replay.Teams, replay.Sides)
+	default:
+		state, _ = e.Start(replay.Teams, replay.FirstTurn)
+	}
+	if state.CurrentTurn != replay.FirstTurn {
+		return state, fmt.Errorf("%s should move first, replay says %s", state.CurrentTurn, replay.FirstTurn)
+	}
+
+	for _, entry := range replay.Log {
+		if entry.Action == "" {
+			continue
+		}
+		action, err := engine.ParseAction(entry.Player, entry.Action)
+		if err != nil {
+			return state, fmt.Errorf("turn %d: %v", entry.Turn, err)
+		}
+		state, _, err = e.Apply(state, action)
+		if err != nil {
+			return state, fmt.Errorf("turn %d: %s played %q: %v", entry.Turn, entry.Player, entry.Action, err)
+		}
+	}
+	return state, nil
+}
+
+// verifyReplay checks that simulating the replay gives the recorded outcome
+func verifyReplay(replay *Replay) error {
+	state, err := simulateReplay(replay)
+	if err != nil {
+		return err
+	}
+	if state.Turn != replay.Turns {
+		return fmt.Errorf("simulation took %d turns, replay says %d", state.Turn, replay.Turns)
+	}
+	for key, want := range replay.FinalHp {
+		if got := finalHp(state)[key]; got != want {
+			return fmt.Errorf("%s ends with %d HP, replay says %d", key, got, want)
+		}
+	}
+	if replay.Result == "knockout" && state.Winner != replay.Winner {
+		return fmt.Errorf("simulation is won by %q, replay says %q", state.Winner, replay.Winner)
+	}
+	return nil
+}
+
+// replayCommand implements "replay [-delay d] [-verify] file": it plays a
+// battle back in the terminal turn by turn, or checks it can be re-simulated
+func replayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	delay := fs.Duration("delay", time.Second, "pause between turns")
+	verify := fs.Bool("verify", false, "only re-simulate the battle and compare the outcome")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: replay [-delay 1s] [-verify] replay.json")
+		return 2
+	}
+	replay, err := loadReplay(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Error loading replay:", err)
+		return 1
+	}
+
+	if *verify {
+		if err := verifyReplay(replay); err != nil {
+			fmt.Println("Replay does NOT match:", err)
+			return 1
+		}
+		fmt.Printf("Replay OK: %s wins by %s after %d turns\n", replay.Winner, replay.Result, replay.Turns)
+		return 0
+	}
+
+	fmt.Printf("Battle %d: %s\n", replay.BattleID, strings.Join(replay.Players, " vs "))
+	for _, name := range replay.Players {
+		fmt.Printf("%s's team:\n", name)
+		for _, p := range replay.Teams[name] {
+			fmt.Printf("  %s %s (Level: %d, HP: %d, ATK: %d, DEF: %d, Speed: %d)\n", p.ID, p.Name, p.Level, p.Hp, p.Atk, p.Def, p.Speed)
+		}
+	}
+	for i, entry := range replay.Log {
+		if entry.Action == "" {
+			fmt.Println(entry.Event)
+			continue
+		}
+		if i > 0 {
+			time.Sleep(*delay)
+		}
+		fmt.Printf("--- Turn %d ---\n", entry.Turn+1)
+		fmt.Printf("> %s: %s\n", entry.Player, entry.Action)
+	}
+	fmt.Printf("Winner: %s (%s)\n", replay.Winner, replay.Result)
+	return 0
+}
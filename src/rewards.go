@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"./engine"
+)
+
+// Every finished battle pays coins into the wallet of its players, kept in
+// the player store:
+//
+//   - the winners get -win-reward
+//   - everybody gets -knockout-reward for every pokemon of the other sides
+//     that fainted
+//   - the winner of a rated battle gets -rating-reward for every rating
+//     point won, so beating a stronger player pays more
+//   - everybody who played until the end gets -participation-reward, which
+//     a player who forfeits, times out or quits does not
+//
+// Battles against AI trainers pay nothing. Every player gets a summary of
+// their rewards after the battle, "@wallet" shows the coins.
+
+var (
+	winReward           = flag.Int("win-reward", 100, "coins the winners of a battle get")
+	knockoutReward      = flag.Int("knockout-reward", 20, "coins for every pokemon of the other sides that fainted")
+	ratingReward        = flag.Int("rating-reward", 2, "coins for every rating point the winner of a rated battle wins")
+	participationReward = flag.Int("participation-reward", 20, "coins for playing a battle until the end")
+)
+
+// reward is a part of what a battle pays a player
+type reward struct {
+	reason string
+	coins  int
+}
+
+// battleRewards lists what a finished battle pays a player. ratingChange is
+// the rating the player won, 0 when the battle does not change ratings.
+func battleRewards(state engine.BattleState, player string, won bool, finished bool, ratingChange int) []reward {
+	var rewards []reward
+	if won {
+		rewards = append(rewards, reward{"win", *winReward})
+	}
+	opponents := state.Opponents(player)
+	if state.Slots == nil {
+		opponents = []string{state.Opponent(player)}
+	}
+	knockouts := 0
+	for _, name := range opponents {
+		knockouts += len(state.Teams[name]) - len(state.Remaining(name))
+	}
+	if knockouts > 0 {
+		rewards = append(rewards, reward{fmt.Sprintf("%d pokemons knocked out", knockouts), knockouts * *knockoutReward})
+	}
+	if ratingChange > 0 {
+		rewards = append(rewards, reward{fmt.Sprintf("%d rating points", ratingChange), ratingChange * *ratingReward})
+	}
+	if finished {
+		rewards = append(rewards, reward{"taking part", *participationReward})
+	}
+	return rewards
+}
+
+// payRewards pays the players of a finished battle and tells each of them
+// what they got. It runs before the ratings change, the caller saves the
+// player store.
+func payRewards(battle *Battle, winners []string, losers []string, result string, conn *net.UDPConn) {
+	for _, name := range append(append([]string(nil), winners...), losers...) {
+		if isAI(name) {
+			return
+		}
+	}
+	ratingChange := 0
+	if len(winners) == 1 && len(losers) == 1 {
+		ratingChange = eloChange(rating(winners[0]), rating(losers[0]))
+	}
+	won := make(map[string]bool)
+	for _, name := range winners {
+		won[name] = true
+	}
+	for _, name := range append(append([]string(nil), winners...), losers...) {
+		finished := players[name] != nil && (won[name] || result == "knockout")
+		change := 0
+		if won[name] {
+			change = ratingChange
+		}
+		rewards := battleRewards(battle.State, name, won[name], finished, change)
+		sendMessage(pay(name, rewards), playerAddr(name), conn)
+	}
+}
+
+// pay adds rewards to the wallet of a player and describes them
+func pay(player string, rewards []reward) string {
+	record := findPlayerRecord(player)
+	total := 0
+	var parts []string
+	for _, r := range rewards {
+		total += r.coins
+		parts = append(parts, fmt.Sprintf("%s +%d", r.reason, r.coins))
+	}
+	record.Coins += total
+	if total == 0 {
+		return fmt.Sprintf("No battle rewards this time. Wallet: %d coins", record.Coins)
+	}
+	return fmt.Sprintf("Battle rewards: %s. Total: +%d coins, wallet: %d coins", strings.Join(parts, ", "), total, record.Coins)
+}
+
+// wallet tells a player how many coins they have
+func wallet(player string) string {
+	return fmt.Sprintf("You have %d coins.", findPlayerRecord(player).Coins)
+}
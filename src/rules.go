@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"./engine"
+)
+
+// A ruleset says which teams may be picked for a battle. The challenger
+// chooses it with "@battle opponent rules=name", every pick is checked
+// against it and rejected with the rule it breaks.
+type Ruleset struct {
+	Name          string   `json:"Name"`
+	TeamSize      int      `json:"TeamSize"`                // pokemons to pick
+	LevelCap      int      `json:"LevelCap,omitempty"`      // pokemons above this level are rejected
+	Level         int      `json:"Level,omitempty"`         // every pokemon fights at this level, with stats from its species
+	Banned        []string `json:"Banned,omitempty"`        // species that cannot be picked
+	SpeciesClause bool     `json:"SpeciesClause,omitempty"` // at most one pokemon of each species
+	Monotype      bool     `json:"Monotype,omitempty"`      // all the pokemons share a type
+	ItemClause    bool     `json:"ItemClause,omitempty"`    // no two pokemons hold the same item
+	RandomTeams   bool     `json:"RandomTeams,omitempty"`   // nobody picks, the server gives random teams
+	Draft         bool     `json:"Draft,omitempty"`         // players ban and pick in turns, see draft.go
+	Bans          int      `json:"Bans,omitempty"`          // species each player bans in a draft
+	Injuries      bool     `json:"Injuries,omitempty"`      // HP lost carries over from battle to battle, see heal.go
+}
+
+const defaultRuleset = "standard"
+
+var rulesetsFile = flag.String("rulesets", "", "JSON file with a list of rulesets to add to the built-in ones")
+
+var rulesets = map[string]Ruleset{
+	"standard": {Name: "standard", TeamSize: 3},
+	"ou": {Name: "ou", TeamSize: 3, SpeciesClause: true, ItemClause: true, Banned: []string{
+		"Mewtwo", "Mew", "Lugia", "Ho-oh", "Kyogre", "Groudon", "Rayquaza", "Dialga", "Palkia", "Giratina", "Arceus",
+		"Reshiram", "Zekrom", "Kyurem", "Xerneas", "Yveltal", "Solgaleo", "Lunala", "Necrozma", "Zacian", "Zamazenta",
+		"Eternatus", "Calyrex", "Koraidon", "Miraidon"}},
+	"little": {Name: "little", TeamSize: 3, LevelCap: 5, SpeciesClause: true},
+	"flat":   {Name: "flat", TeamSize: 3, Level: 50, SpeciesClause: true},
+	"mono":   {Name: "mono", TeamSize: 3, SpeciesClause: true, Monotype: true},
+	"quick":  {Name: "quick", TeamSize: 2},
+	"random": {Name: "random", TeamSize: 3, RandomTeams: true},
+	"draft":  {Name: "draft", TeamSize: 3, Draft: true, Bans: 1, SpeciesClause: true},
+	"injury": {Name: "injury", TeamSize: 3, Injuries: true},
+}
+
+// loadRulesets adds the rulesets of a file to the built-in ones, a ruleset
+// with the name of a built-in one replaces it
+func loadRulesets(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var list []Ruleset
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, rules := range list {
+		if rules.Name == "" || rules.TeamSize < 1 {
+			return fmt.Errorf("ruleset %q needs a name and a team size", rules.Name)
+		}
+		rulesets[rules.Name] = rules
+	}
+	return nil
+}
+
+// String describes the ruleset in one line
+func (r Ruleset) String() string {
+	rules := []string{fmt.Sprintf("%d pokemons", r.TeamSize)}
+	if r.LevelCap > 0 {
+		rules = append(rules, fmt.Sprintf("level %d at most", r.LevelCap))
+	}
+	if r.Level > 0 {
+		rules = append(rules, fmt.Sprintf("everybody at level %d", r.Level))
+	}
+	if len(r.Banned) > 0 {
+		rules = append(rules, fmt.Sprintf("%d banned species", len(r.Banned)))
+	}
+	if r.SpeciesClause {
+		rules = append(rules, "species clause")
+	}
+	if r.Monotype {
+		rules = append(rules, "monotype")
+	}
+	if r.ItemClause {
+		rules = append(rules, "item clause")
+	}
+	if r.RandomTeams {
+		rules = append(rules, "random teams")
+	}
+	if r.Draft {
+		rules = append(rules, fmt.Sprintf("draft with %d bans each", r.Bans))
+	}
+	if r.Injuries {
+		rules = append(rules, "injuries carry over")
+	}
+	return r.Name + ": " + strings.Join(rules, ", ")
+}
+
+// listRulesets describes every ruleset, for @rules
+func listRulesets() string {
+	var lines []string
+	for _, rules := range rulesets {
+		lines = append(lines, rules.String())
+	}
+	sort.Strings(lines)
+	return "Rulesets, choose one with @battle opponent rules=name:\n" + strings.Join(lines, "\n")
+}
+
+// allowsFormat tells if teams of this ruleset can fill the field of a format
+func (r Ruleset) allowsFormat(format engine.BattleFormat) bool {
+	return format != engine.Doubles || r.TeamSize >= engine.DoublesSlots
+}
+
+// pickTeam checks the pokemons a player picked and gives their battle
+// pokemons, the error says which rule the pick breaks
+func (r Ruleset) pickTeam(player string, ids []string) ([]engine.BattlePokemon, error) {
+	if len(ids) != r.TeamSize {
+		return nil, fmt.Errorf("pick exactly %d pokemons", r.TeamSize)
+	}
+	return r.checkTeam(player, ids)
+}
+
+// checkTeam checks every rule but the team size
+func (r Ruleset) checkTeam(player string, ids []string) ([]engine.BattlePokemon, error) {
+	var team []engine.BattlePokemon
+	var types [][]string
+	species := make(map[string]bool)
+	items := make(map[string]bool)
+	for i, id := range ids {
+		for _, other := range ids[:i] {
+			if other == id {
+				return nil, fmt.Errorf("%s is picked twice", id)
+			}
+		}
+		p := findPlayerPokemonByPokeID(player, id)
+		if p == nil {
+			return nil, fmt.Errorf("you have no pokemon %s", id)
+		}
+		for _, banned := range r.Banned {
+			if strings.EqualFold(banned, p.Name) {
+				return nil, fmt.Errorf("%s is banned in %s", p.Name, r.Name)
+			}
+		}
+		if r.LevelCap > 0 && p.Level > r.LevelCap {
+			return nil, fmt.Errorf("%s is level %d, the level cap is %d", p.Name, p.Level, r.LevelCap)
+		}
+		if r.Injuries && injuredHp(p) == 0 {
+			return nil, fmt.Errorf("%s has fainted, @heal it first", p.ID)
+		}
+		if r.SpeciesClause && species[p.Name] {
+			return nil, fmt.Errorf("species clause: only one %s", p.Name)
+		}
+		species[p.Name] = true
+		if r.ItemClause && p.Item != "" && items[engine.EffectKey(p.Item)] {
+			return nil, fmt.Errorf("item clause: only one %s", p.Item)
+		}
+		items[engine.EffectKey(p.Item)] = true
+		team = append(team, r.battlePokemon(p))
+		types = append(types, speciesTypes(p))
+	}
+	if r.Monotype && len(types) > 0 && len(sharedTypes(types)) == 0 {
+		return nil, fmt.Errorf("monotype: all the pokemons must share a type")
+	}
+	return team, nil
+}
+
+// autoPick chooses pokemons for a player who did not pick in time: the first
+// ones of their list that keep the team valid
+func (r Ruleset) autoPick(player string) []string {
+	var ids []string
+	for _, p := range findPlayerPokemonByPlayer(player) {
+		if len(ids) == r.TeamSize {
+			break
+		}
+		if _, err := r.checkTeam(player, append(ids, p.ID)); err == nil {
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids
+}
+
+// battlePokemon makes the battle pokemon of a player's pokemon under the
+// ruleset, with the stats of its species at the ruleset's level if it has one
+func (r Ruleset) battlePokemon(p *PlayerPokeInfo) engine.BattlePokemon {
+	pokemon := engine.BattlePokemon{
+		Name:        p.Name,
+		ID:          p.ID,
+		Level:       p.Level,
+		Exp:         p.Exp,
+		Hp:          p.Hp,
+		Types:       p.Types,
+		Atk:         p.Atk,
+		Def:         p.Def,
+		SpAtk:       p.SpAtk,
+		SpDef:       p.SpDef,
+		Speed:       p.Speed,
+		TypeDefense: p.TypeDefense,
+	}
+	if r.Level > 0 {
+		if species := findPokemonByNameOrID(p.Name); species != nil {
+			pokemon = atLevel(battlePokemonFromPokedex(species, p.ID), r.Level)
+		}
+	}
+	pokemon.Ability, pokemon.Item = p.Ability, p.Item
+	if r.Injuries && p.Damage > 0 && p.Hp > 0 {
+		pokemon.MaxHp = pokemon.Hp
+		pokemon.Hp -= injury(p.Damage, pokemon.Hp, p.Damage >= p.Hp)
+	}
+	if pokemon.Ability == "" {
+		if species := findPokemonByNameOrID(p.Name); species != nil {
+			pokemon.Ability = defaultAbility(species)
+		}
+	}
+	return pokemon
+}
+
+// atLevel gives a pokemon with base stats the stats it has at a level
+func atLevel(p engine.BattlePokemon, level int) engine.BattlePokemon {
+	stat := func(base int) int { return base*2*level/100 + 5 }
+	p.Level = level
+	p.Hp = p.Hp*2*level/100 + level + 10
+	p.Atk, p.Def = stat(p.Atk), stat(p.Def)
+	p.SpAtk, p.SpDef = stat(p.SpAtk), stat(p.SpDef)
+	p.Speed = stat(p.Speed)
+	return p
+}
+
+// speciesTypes gives the types of a player's pokemon, from the pokedex when
+// the player store does not have them
+func speciesTypes(p *PlayerPokeInfo) []string {
+	if len(p.Types) == 0 {
+		if species := findPokemonByNameOrID(p.Name); species != nil {
+			return species.Types
+		}
+	}
+	return p.Types
+}
+
+// sharedTypes lists the types that are in every list
+func sharedTypes(types [][]string) []string {
+	var shared []string
+	for _, t := range types[0] {
+		all := true
+		for _, other := range types[1:] {
+			has := false
+			for _, o := range other {
+				has = has || o == t
+			}
+			all = all && has
+		}
+		if all {
+			shared = append(shared, t)
+		}
+	}
+	return shared
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func rulesRecords() []PlayerPokemon {
+	return []PlayerPokemon{{Owner: "ash", PlayerPokeInfo: []PlayerPokeInfo{
+		{ID: "#001", Name: "Pikachu", Level: 5, Hp: 35, Types: []string{"Electric"}, Item: "Oran Berry"},
+		{ID: "#002", Name: "Pikachu", Level: 7, Hp: 38, Types: []string{"Electric"}},
+		{ID: "#003", Name: "Squirtle", Level: 30, Hp: 80, Types: []string{"Water"}, Item: "Oran Berry"},
+		{ID: "#004", Name: "Mewtwo", Level: 70, Hp: 250, Types: []string{"Psychic"}},
+		{ID: "#005", Name: "Magikarp", Level: 5, Hp: 20, Types: []string{"Water"}, Damage: 20},
+		{ID: "#006", Name: "Psyduck", Level: 10, Hp: 40, Types: []string{"Water"}},
+	}}}
+}
+
+func TestCheckTeam(t *testing.T) {
+	tests := []struct {
+		rules string
+		ids   []string
+		err   string // part of the error, none when empty
+	}{
+		{"standard", []string{"#001", "#003", "#004"}, ""},
+		{"standard", []string{"#001", "#001"}, "#001 is picked twice"},
+		{"standard", []string{"#009"}, "you have no pokemon #009"},
+		{"ou", []string{"#004"}, "Mewtwo is banned in ou"},
+		{"little", []string{"#001", "#003"}, "Squirtle is level 30, the level cap is 5"},
+		{"injury", []string{"#005"}, "#005 has fainted"},
+		{"ou", []string{"#001", "#002"}, "species clause: only one Pikachu"},
+		{"ou", []string{"#001", "#003"}, "item clause: only one Oran Berry"},
+		{"mono", []string{"#001", "#003"}, "monotype"},
+		{"mono", []string{"#003", "#006"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rules+" "+strings.Join(tt.ids, " "), func(t *testing.T) {
+			useRecords(t, rulesRecords())
+			team, err := rulesets[tt.rules].checkTeam("ash", tt.ids)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want %q", err, tt.err)
+			case tt.err == "" && len(team) != len(tt.ids):
+				t.Errorf("team of %d pokemons, want %d", len(team), len(tt.ids))
+			}
+		})
+	}
+}
+
+func TestPickTeamSize(t *testing.T) {
+	useRecords(t, rulesRecords())
+	if _, err := rulesets["standard"].pickTeam("ash", []string{"#001", "#003"}); err == nil || err.Error() != "pick exactly 3 pokemons" {
+		t.Errorf("error %v, want the team size", err)
+	}
+}
+
+func TestAutoPick(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  []string
+	}{
+		{"standard", []string{"#001", "#002", "#003"}},
+		{"ou", []string{"#001", "#005", "#006"}},
+		{"little", []string{"#001", "#005"}},
+		{"injury", []string{"#001", "#002", "#003"}},
+		{"mono", []string{"#001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rules, func(t *testing.T) {
+			useRecords(t, rulesRecords())
+			if got := rulesets[tt.rules].autoPick("ash"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("picked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
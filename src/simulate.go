@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"./engine"
+)
+
+// maxSimulatedTurns ends a battle as a draw when no pokemon can hurt the other
+const maxSimulatedTurns = 500
+
+type (
+	SimulationReport struct {
+		Battles  int             `json:"battles"`
+		Draws    int             `json:"draws"`
+		AvgTurns float64         `json:"avg_turns"`
+		Rows     []SimulationRow `json:"species"`
+	}
+
+	SimulationRow struct { // results of one species, on one side when teams are fixed
+		Side     string  `json:"side"`
+		Species  string  `json:"species"`
+		Battles  int     `json:"battles"`
+		Wins     int     `json:"wins"`
+		Draws    int     `json:"draws"`
+		WinRate  float64 `json:"win_rate"`
+		AvgTurns float64 `json:"avg_turns"`
+		Hits     int     `json:"hits"`
+		DmgMean  float64 `json:"dmg_mean"`
+		DmgMin   int     `json:"dmg_min"`
+		DmgP50   int     `json:"dmg_p50"`
+		DmgP90   int     `json:"dmg_p90"`
+		DmgMax   int     `json:"dmg_max"`
+		turns    int
+		damages  []int
+	}
+)
+
+// simulateCommand implements "simulate": it runs headless battles with the
+// server's engine between two given teams, or random teams from the pokedex,
+// and reports win rates, turns and damage per species as CSV or JSON
+func simulateCommand(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	battles := fs.Int("battles", 1000, "number of battles to run")
+	team1 := fs.String("team1", "", "comma separated species names or pokedex IDs, random teams when empty")
+	team2 := fs.String("team2", "", "comma separated species names or pokedex IDs, random teams when empty")
+	teamSize := fs.Int("size", 3, "size of random teams")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "seed of the battles, the same seed gives the same report")
+	format := fs.String("format", "csv", "csv or json")
+	out := fs.String("out", "", "file to write the report to, stdout when empty")
+	pokedexFile := fs.String("pokedex", pokedexData, "pokedex JSON file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*team1 == "") != (*team2 == "") || *battles <= 0 || (*format != "csv" && *format != "json") {
+		fmt.Println("Usage: simulate [-battles 1000] [-team1 a,b,c -team2 d,e,f] [-size 3] [-seed n] [-format csv|json] [-out file]")
+		return 2
+	}
+	if err := loadPokedex(*pokedexFile); err != nil {
+		fmt.Println("Error loading pokedex data:", err)
+		return 1
+	}
+
+	var fixed map[string][]engine.BattlePokemon
+	if *team1 != "" {
+		fixed = make(map[string][]engine.BattlePokemon)
+		for side, spec := range map[string]string{"team1": *team1, "team2": *team2} {
+			team, err := parseTeam(spec)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 2
+			}
+			fixed[side] = team
+		}
+	}
+
+	report := runSimulation(*battles, fixed, *teamSize, *seed)
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Println("Error creating report:", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	if *format == "json" {
+		err = writeReportJSON(w, report)
+	} else {
+		err = writeReportCSV(w, report)
+	}
+	if err != nil {
+		fmt.Println("Error writing report:", err)
+		return 1
+	}
+	return 0
+}
+
+// parseTeam finds the species of a comma separated team in the pokedex
+func parseTeam(spec string) ([]engine.BattlePokemon, error) {
+	var team []engine.BattlePokemon
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		var species *Pokemon
+		for i := range pokedex {
+			if strings.EqualFold(pokedex[i].Name, name) || pokedex[i].Id == name {
+				species = &pokedex[i]
+				break
+			}
+		}
+		if species == nil {
+			return nil, fmt.Errorf("pokemon %q is not in the pokedex", name)
+		}
+		team = append(team, battlePokemonFromPokedex(species, fmt.Sprintf("#%03d", len(team)+1)))
+	}
+	return team, nil
+}
+
+// randomTeam picks size different species that can battle
+func randomTeam(rng *rand.Rand, size int) []engine.BattlePokemon {
+	var team []engine.BattlePokemon
+	picked := make(map[int]bool)
+	for len(team) < size {
+		i := rng.Intn(len(pokedex))
+		if picked[i] || pokedex[i].PokeInfo.Hp == 0 {
+			continue
+		}
+		picked[i] = true
+		team = append(team, battlePokemonFromPokedex(&pokedex[i], fmt.Sprintf("#%03d", len(team)+1)))
+	}
+	return team
+}
+
+func runSimulation(battles int, fixed map[string][]engine.BattlePokemon, teamSize int, seed int64) SimulationReport {
+	rng := rand.New(rand.NewSource(seed))
+	clock := func() time.Time { return time.Unix(0, seed) }
+	rows := make(map[string]*SimulationRow)
+	report := SimulationReport{Battles: battles}
+	totalTurns := 0
+
+	for i := 0; i < battles; i++ {
+		teams := fixed
+		if teams == nil {
+			teams = map[string][]engine.BattlePokemon{"team1": randomTeam(rng, teamSize), "team2": randomTeam(rng, teamSize)}
+		}
+		state, events := simulateBattle(engine.NewEngine(rng.Int63(), clock), teams, "team1")
+		totalTurns += state.Turn
+		if state.Winner == "" {
+			report.Draws++
+		}
+
+		for _, side := range state.Players {
+			for _, p := range teams[side] {
+				row := simulationRow(rows, fixed != nil, side, p.Name)
+				row.Battles++
+				row.turns += state.Turn
+				if state.Winner == side {
+					row.Wins++
+				} else if state.Winner == "" {
+					row.Draws++
+				}
+			}
+		}
+		for _, event := range events {
+			if event.Kind == engine.EventHit {
+				row := simulationRow(rows, fixed != nil, event.Player, event.Pokemon)
+				row.damages = append(row.damages, event.Damage)
+			}
+		}
+	}
+
+	report.AvgTurns = float64(totalTurns) / float64(battles)
+	for _, row := range rows {
+		row.WinRate = float64(row.Wins) / float64(row.Battles)
+		row.AvgTurns = float64(row.turns) / float64(row.Battles)
+		row.Hits = len(row.damages)
+		if row.Hits > 0 {
+			sort.Ints(row.damages)
+			sum := 0
+			for _, dmg := range row.damages {
+				sum += dmg
+			}
+			row.DmgMean = float64(sum) / float64(row.Hits)
+			row.DmgMin = row.damages[0]
+			row.DmgP50 = row.damages[row.Hits*50/100]
+			row.DmgP90 = row.damages[row.Hits*90/100]
+			row.DmgMax = row.damages[row.Hits-1]
+		}
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].Side != report.Rows[j].Side {
+			return report.Rows[i].Side < report.Rows[j].Side
+		}
+		return report.Rows[i].Species < report.Rows[j].Species
+	})
+	return report
+}
+
+// simulateBattle plays a battle where every pokemon always attacks and a
+// fainted pokemon is replaced by the next one in the team
+func simulateBattle(e *engine.Engine, teams map[string][]engine.BattlePokemon, tieBreaker string) (engine.BattleState, []engine.Event) {
+	state, events := e.Start(teams, tieBreaker)
+	for state.Winner == "" && state.Turn < maxSimulatedTurns {
+		action := engine.Action{Player: state.CurrentTurn, Kind: engine.ActionAttack}
+		if state.ForcedSwitch[state.CurrentTurn] {
+			action = engine.Action{Player: state.CurrentTurn, Kind: engine.ActionChange, PokemonID: state.Remaining(state.CurrentTurn)[0].ID}
+		}
+		next, more, err := e.Apply(state, action)
+		if err != nil {
+			break
+		}
+		state = next
+		events = append(events, more...)
+	}
+	return state, events
+}
+
+func simulationRow(rows map[string]*SimulationRow, bySide bool, side string, species string) *SimulationRow {
+	if !bySide {
+		side = "random"
+	}
+	key := side + "/" + species
+	if rows[key] == nil {
+		rows[key] = &SimulationRow{Side: side, Species: species}
+	}
+	return rows[key]
+}
+
+func writeReportJSON(w io.Writer, report SimulationReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func writeReportCSV(w io.Writer, report SimulationReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"side", "species", "battles", "wins", "draws", "win_rate", "avg_turns", "hits", "dmg_mean", "dmg_min", "dmg_p50", "dmg_p90", "dmg_max"})
+	for _, row := range report.Rows {
+		out.Write([]string{
+			row.Side, row.Species, strconv.Itoa(row.Battles), strconv.Itoa(row.Wins), strconv.Itoa(row.Draws),
+			strconv.FormatFloat(row.WinRate, 'f', 4, 64), strconv.FormatFloat(row.AvgTurns, 'f', 2, 64),
+			strconv.Itoa(row.Hits), strconv.FormatFloat(row.DmgMean, 'f', 2, 64),
+			strconv.Itoa(row.DmgMin), strconv.Itoa(row.DmgP50), strconv.Itoa(row.DmgP90), strconv.Itoa(row.DmgMax),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
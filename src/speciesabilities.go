@@ -0,0 +1,187 @@
+package main
+
+// speciesAbilities are the abilities of the species the pokedex does not
+// list abilities for, the first one by default, the hidden one last. It
+// covers the first generation, the other species of the player store and the
+// species that bring the weather, a terrain or Speed Boost to the battle.
+// Abilities without an effect in the engine (see engine/abilities.go) are
+// listed too: a pokemon can have them, they just do nothing yet. A species
+// that is not here has no ability.
+var speciesAbilities = map[string][]string{
+	"Bulbasaur":  {"Overgrow", "Chlorophyll"},
+	"Ivysaur":    {"Overgrow", "Chlorophyll"},
+	"Venusaur":   {"Overgrow", "Chlorophyll"},
+	"Charmander": {"Blaze", "Solar Power"},
+	"Charmeleon": {"Blaze", "Solar Power"},
+	"Charizard":  {"Blaze", "Solar Power"},
+	"Squirtle":   {"Torrent", "Rain Dish"},
+	"Wartortle":  {"Torrent", "Rain Dish"},
+	"Blastoise":  {"Torrent", "Rain Dish"},
+	"Caterpie":   {"Shield Dust", "Run Away"},
+	"Metapod":    {"Shed Skin"},
+	"Butterfree": {"Compound Eyes", "Tinted Lens"},
+	"Weedle":     {"Shield Dust", "Run Away"},
+	"Kakuna":     {"Shed Skin"},
+	"Beedrill":   {"Swarm", "Sniper"},
+	"Pidgey":     {"Keen Eye", "Tangled Feet", "Big Pecks"},
+	"Pidgeotto":  {"Keen Eye", "Tangled Feet", "Big Pecks"},
+	"Pidgeot":    {"Keen Eye", "Tangled Feet", "Big Pecks"},
+	"Rattata":    {"Run Away", "Guts", "Hustle"},
+	"Raticate":   {"Run Away", "Guts", "Hustle"},
+	"Spearow":    {"Keen Eye", "Sniper"},
+	"Fearow":     {"Keen Eye", "Sniper"},
+	"Ekans":      {"Intimidate", "Shed Skin", "Unnerve"},
+	"Arbok":      {"Intimidate", "Shed Skin", "Unnerve"},
+	"Pikachu":    {"Static", "Lightning Rod"},
+	"Raichu":     {"Static", "Lightning Rod"},
+	"Sandshrew":  {"Sand Veil", "Sand Rush"},
+	"Sandslash":  {"Sand Veil", "Sand Rush"},
+	"Nidoran♀":   {"Poison Point", "Rivalry", "Hustle"},
+	"Nidorina":   {"Poison Point", "Rivalry", "Hustle"},
+	"Nidoqueen":  {"Poison Point", "Rivalry", "Sheer Force"},
+	"Nidoran♂":   {"Poison Point", "Rivalry", "Hustle"},
+	"Nidorino":   {"Poison Point", "Rivalry", "Hustle"},
+	"Nidoking":   {"Poison Point", "Rivalry", "Sheer Force"},
+	"Clefairy":   {"Cute Charm", "Magic Guard", "Friend Guard"},
+	"Clefable":   {"Cute Charm", "Magic Guard", "Unaware"},
+	"Vulpix":     {"Flash Fire", "Drought"},
+	"Ninetales":  {"Flash Fire", "Drought"},
+	"Jigglypuff": {"Cute Charm", "Competitive", "Friend Guard"},
+	"Wigglytuff": {"Cute Charm", "Competitive", "Frisk"},
+	"Zubat":      {"Inner Focus", "Infiltrator"},
+	"Golbat":     {"Inner Focus", "Infiltrator"},
+	"Oddish":     {"Chlorophyll", "Run Away"},
+	"Gloom":      {"Chlorophyll", "Stench"},
+	"Vileplume":  {"Chlorophyll", "Effect Spore"},
+	"Paras":      {"Effect Spore", "Dry Skin", "Damp"},
+	"Parasect":   {"Effect Spore", "Dry Skin", "Damp"},
+	"Venonat":    {"Compound Eyes", "Tinted Lens", "Run Away"},
+	"Venomoth":   {"Shield Dust", "Tinted Lens", "Wonder Skin"},
+	"Diglett":    {"Sand Veil", "Arena Trap", "Sand Force"},
+	"Dugtrio":    {"Sand Veil", "Arena Trap", "Sand Force"},
+	"Meowth":     {"Pickup", "Technician", "Unnerve"},
+	"Persian":    {"Limber", "Technician", "Unnerve"},
+	"Psyduck":    {"Damp", "Cloud Nine", "Swift Swim"},
+	"Golduck":    {"Damp", "Cloud Nine", "Swift Swim"},
+	"Mankey":     {"Vital Spirit", "Anger Point", "Defiant"},
+	"Primeape":   {"Vital Spirit", "Anger Point", "Defiant"},
+	"Growlithe":  {"Intimidate", "Flash Fire", "Justified"},
+	"Arcanine":   {"Intimidate", "Flash Fire", "Justified"},
+	"Poliwag":    {"Water Absorb", "Damp", "Swift Swim"},
+	"Poliwhirl":  {"Water Absorb", "Damp", "Swift Swim"},
+	"Poliwrath":  {"Water Absorb", "Damp", "Swift Swim"},
+	"Abra":       {"Synchronize", "Inner Focus", "Magic Guard"},
+	"Kadabra":    {"Synchronize", "Inner Focus", "Magic Guard"},
+	"Alakazam":   {"Synchronize", "Inner Focus", "Magic Guard"},
+	"Machop":     {"Guts", "No Guard", "Steadfast"},
+	"Machoke":    {"Guts", "No Guard", "Steadfast"},
+	"Machamp":    {"Guts", "No Guard", "Steadfast"},
+	"Bellsprout": {"Chlorophyll", "Gluttony"},
+	"Weepinbell": {"Chlorophyll", "Gluttony"},
+	"Victreebel": {"Chlorophyll", "Gluttony"},
+	"Tentacool":  {"Clear Body", "Liquid Ooze", "Rain Dish"},
+	"Tentacruel": {"Clear Body", "Liquid Ooze", "Rain Dish"},
+	"Geodude":    {"Rock Head", "Sturdy", "Sand Veil"},
+	"Graveler":   {"Rock Head", "Sturdy", "Sand Veil"},
+	"Golem":      {"Rock Head", "Sturdy", "Sand Veil"},
+	"Ponyta":     {"Run Away", "Flash Fire", "Flame Body"},
+	"Rapidash":   {"Run Away", "Flash Fire", "Flame Body"},
+	"Slowpoke":   {"Oblivious", "Own Tempo", "Regenerator"},
+	"Slowbro":    {"Oblivious", "Own Tempo", "Regenerator"},
+	"Magnemite":  {"Magnet Pull", "Sturdy", "Analytic"},
+	"Magneton":   {"Magnet Pull", "Sturdy", "Analytic"},
+	"Farfetch'd": {"Keen Eye", "Inner Focus", "Defiant"},
+	"Doduo":      {"Run Away", "Early Bird", "Tangled Feet"},
+	"Dodrio":     {"Run Away", "Early Bird", "Tangled Feet"},
+	"Seel":       {"Thick Fat", "Hydration", "Ice Body"},
+	"Dewgong":    {"Thick Fat", "Hydration", "Ice Body"},
+	"Grimer":     {"Stench", "Sticky Hold", "Poison Touch"},
+	"Muk":        {"Stench", "Sticky Hold", "Poison Touch"},
+	"Shellder":   {"Shell Armor", "Skill Link", "Overcoat"},
+	"Cloyster":   {"Shell Armor", "Skill Link", "Overcoat"},
+	"Gastly":     {"Levitate"},
+	"Haunter":    {"Levitate"},
+	"Gengar":     {"Cursed Body"},
+	"Onix":       {"Rock Head", "Sturdy", "Weak Armor"},
+	"Drowzee":    {"Insomnia", "Forewarn", "Inner Focus"},
+	"Hypno":      {"Insomnia", "Forewarn", "Inner Focus"},
+	"Krabby":     {"Hyper Cutter", "Shell Armor", "Sheer Force"},
+	"Kingler":    {"Hyper Cutter", "Shell Armor", "Sheer Force"},
+	"Voltorb":    {"Soundproof", "Static", "Aftermath"},
+	"Electrode":  {"Soundproof", "Static", "Aftermath"},
+	"Exeggcute":  {"Chlorophyll", "Harvest"},
+	"Exeggutor":  {"Chlorophyll", "Harvest"},
+	"Cubone":     {"Rock Head", "Lightning Rod", "Battle Armor"},
+	"Marowak":    {"Rock Head", "Lightning Rod", "Battle Armor"},
+	"Hitmonlee":  {"Limber", "Reckless", "Unburden"},
+	"Hitmonchan": {"Keen Eye", "Iron Fist", "Inner Focus"},
+	"Lickitung":  {"Own Tempo", "Oblivious", "Cloud Nine"},
+	"Koffing":    {"Levitate", "Neutralizing Gas", "Stench"},
+	"Weezing":    {"Levitate", "Neutralizing Gas", "Stench"},
+	"Rhyhorn":    {"Lightning Rod", "Rock Head", "Reckless"},
+	"Rhydon":     {"Lightning Rod", "Rock Head", "Reckless"},
+	"Chansey":    {"Natural Cure", "Serene Grace", "Healer"},
+	"Tangela":    {"Chlorophyll", "Leaf Guard", "Regenerator"},
+	"Kangaskhan": {"Early Bird", "Scrappy", "Inner Focus"},
+	"Horsea":     {"Swift Swim", "Sniper", "Damp"},
+	"Seadra":     {"Poison Point", "Sniper", "Damp"},
+	"Goldeen":    {"Swift Swim", "Water Veil", "Lightning Rod"},
+	"Seaking":    {"Swift Swim", "Water Veil", "Lightning Rod"},
+	"Staryu":     {"Illuminate", "Natural Cure", "Analytic"},
+	"Starmie":    {"Illuminate", "Natural Cure", "Analytic"},
+	"Mr. Mime":   {"Soundproof", "Filter", "Technician"},
+	"Scyther":    {"Swarm", "Technician", "Steadfast"},
+	"Jynx":       {"Oblivious", "Forewarn", "Dry Skin"},
+	"Electabuzz": {"Static", "Vital Spirit"},
+	"Magmar":     {"Flame Body", "Vital Spirit"},
+	"Pinsir":     {"Hyper Cutter", "Mold Breaker", "Moxie"},
+	"Tauros":     {"Intimidate", "Anger Point", "Sheer Force"},
+	"Magikarp":   {"Swift Swim", "Rattled"},
+	"Gyarados":   {"Intimidate", "Moxie"},
+	"Lapras":     {"Water Absorb", "Shell Armor", "Hydration"},
+	"Ditto":      {"Limber", "Imposter"},
+	"Eevee":      {"Run Away", "Adaptability", "Anticipation"},
+	"Vaporeon":   {"Water Absorb", "Hydration"},
+	"Jolteon":    {"Volt Absorb", "Quick Feet"},
+	"Flareon":    {"Flash Fire", "Guts"},
+	"Porygon":    {"Trace", "Download", "Analytic"},
+	"Omanyte":    {"Swift Swim", "Shell Armor", "Weak Armor"},
+	"Omastar":    {"Swift Swim", "Shell Armor", "Weak Armor"},
+	"Kabuto":     {"Swift Swim", "Battle Armor", "Weak Armor"},
+	"Kabutops":   {"Swift Swim", "Battle Armor", "Weak Armor"},
+	"Aerodactyl": {"Rock Head", "Pressure", "Unnerve"},
+	"Snorlax":    {"Immunity", "Thick Fat", "Gluttony"},
+	"Articuno":   {"Pressure", "Snow Cloak"},
+	"Zapdos":     {"Pressure", "Static"},
+	"Moltres":    {"Pressure", "Flame Body"},
+	"Dratini":    {"Shed Skin", "Marvel Scale"},
+	"Dragonair":  {"Shed Skin", "Marvel Scale"},
+	"Dragonite":  {"Inner Focus", "Multiscale"},
+	"Mewtwo":     {"Pressure", "Unnerve"},
+	"Mew":        {"Synchronize"},
+
+	"Cyndaquil": {"Blaze", "Flash Fire"},
+	"Furret":    {"Run Away", "Keen Eye", "Frisk"},
+	"Cleffa":    {"Cute Charm", "Magic Guard", "Friend Guard"},
+	"Politoed":  {"Water Absorb", "Damp", "Drizzle"},
+	"Hoppip":    {"Chlorophyll", "Leaf Guard", "Infiltrator"},
+	"Murkrow":   {"Insomnia", "Super Luck", "Prankster"},
+	"Lampent":   {"Flash Fire", "Flame Body", "Infiltrator"},
+
+	"Tyranitar":  {"Sand Stream", "Unnerve"},
+	"Torchic":    {"Blaze", "Speed Boost"},
+	"Combusken":  {"Blaze", "Speed Boost"},
+	"Blaziken":   {"Blaze", "Speed Boost"},
+	"Ninjask":    {"Speed Boost", "Infiltrator"},
+	"Kyogre":     {"Drizzle"},
+	"Groudon":    {"Drought"},
+	"Yanmega":    {"Speed Boost", "Tinted Lens", "Frisk"},
+	"Hippowdon":  {"Sand Stream", "Sand Force"},
+	"Abomasnow":  {"Snow Warning", "Soundproof"},
+	"Garchomp":   {"Sand Veil", "Rough Skin"},
+	"Ferrothorn": {"Iron Barbs", "Anticipation"},
+	"Tapu Koko":  {"Electric Surge", "Telepathy"},
+	"Tapu Lele":  {"Psychic Surge", "Telepathy"},
+	"Tapu Bulu":  {"Grassy Surge", "Telepathy"},
+	"Tapu Fini":  {"Misty Surge", "Telepathy"},
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listBattles describes every battle that can be spectated
+func listBattles() string {
+	var lines []string
+	for id, battle := range gameStates {
+		if battle.Status != BattlePicking && battle.Status != BattleActive {
+			continue
+		}
+		kind := "casual " + string(battle.Format)
+		if battle.Ranked {
+			kind = "ranked " + string(battle.Format)
+		}
+		if battle.Rules.Name != defaultRuleset {
+			kind += " (" + battle.Rules.Name + ")"
+		}
+		lines = append(lines, fmt.Sprintf("Battle %d: %s | %s | %s | turn %d | %d spectators",
+			id, strings.Join(battlePlayerNames(battle), " vs "), kind, battle.Status, battle.State.Turn, len(battle.Spectators)))
+	}
+	if len(lines) == 0 {
+		return "No battle is running right now."
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// findSpectatableBattle finds a running battle by its ID or by one of its players
+func findSpectatableBattle(key string) *Battle {
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		if battle, ok := gameStates[id]; ok && (battle.Status == BattlePicking || battle.Status == BattleActive) {
+			return battle
+		}
+	}
+	if p, ok := players[key]; ok && p.battleID != 0 {
+		return gameStates[p.battleID]
+	}
+	return nil
+}
+
+func spectate(battle *Battle, name string, conn *net.UDPConn) {
+	unspectate(name, conn)
+	battle.Spectators[name] = true
+	players[name].spectating = battle.battleID
+
+	sendMessage(fmt.Sprintf("You are spectating %s. Use @spec message to chat with other spectators, @unspectate to leave.",
+		strings.Join(battlePlayerNames(battle), " vs ")), players[name].Addr, conn)
+	if battle.Status == BattleActive && battle.State.Slots != nil {
+		for _, player := range battlePlayerNames(battle) {
+			sendMessage(fmt.Sprintf("[spectate] %s's pokemons: %s", player, describeSlots(battle.State, player)), players[name].Addr, conn)
+		}
+	} else if battle.Status == BattleActive {
+		for _, player := range battlePlayerNames(battle) {
+			if p := battle.State.ActivePokemon(player); p != nil {
+				sendMessage(fmt.Sprintf("[spectate] %s's active pokemon: %s (HP: %d)", player, p.Name, p.Hp), players[name].Addr, conn)
+			}
+		}
+		sendMessage(fmt.Sprintf("[spectate] It is %s's turn.", battle.State.CurrentTurn), players[name].Addr, conn)
+	}
+	notifySpectators(battle, name+" started spectating.", conn)
+}
+
+func unspectate(name string, conn *net.UDPConn) {
+	p := players[name]
+	if p == nil || p.spectating == 0 {
+		return
+	}
+	if battle, ok := gameStates[p.spectating]; ok {
+		delete(battle.Spectators, name)
+		notifySpectators(battle, name+" stopped spectating.", conn)
+	}
+	p.spectating = 0
+}
+
+// notifySpectators streams a battle event to everybody watching the battle
+func notifySpectators(battle *Battle, message string, conn *net.UDPConn) {
+	for name := range battle.Spectators {
+		sendMessage("[spectate] "+message, playerAddr(name), conn)
+	}
+}
+
+// spectatorChat sends a message to the other spectators of the same battle,
+// the players in the battle never see it
+func spectatorChat(message string, senderName string, conn *net.UDPConn) {
+	battle, ok := gameStates[players[senderName].spectating]
+	if !ok {
+		sendMessage("You are not spectating any battle!", players[senderName].Addr, conn)
+		return
+	}
+	for name := range battle.Spectators {
+		if name != senderName {
+			sendMessage(senderName+" (spectator): "+message, playerAddr(name), conn)
+		}
+	}
+}
+
+// releaseSpectators sends everybody watching a battle that is over back to the lobby
+func releaseSpectators(battle *Battle) {
+	for name := range battle.Spectators {
+		if p, ok := players[name]; ok && p.spectating == battle.battleID {
+			p.spectating = 0
+		}
+	}
+	battle.Spectators = make(map[string]bool)
+}
+
+func battlePlayerNames(battle *Battle) []string {
+	var names []string
+	for name := range battle.Players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
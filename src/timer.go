@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"./engine"
+)
+
+var (
+	turnTimeout    = flag.Duration("turn-timeout", 60*time.Second, "time a player has to @attack or @change on their turn")
+	pickTimeout    = flag.Duration("pick-timeout", 90*time.Second, "time both players have to @pick their pokemons")
+	timeoutWarning = flag.Duration("timeout-warning", 15*time.Second, "how long before a timeout the player gets a warning")
+	maxTimeouts    = flag.Int("max-timeouts", 3, "number of timed out turns after which a player forfeits the battle")
+)
+
+// startPickTimer gives the players pickTimeout to send @pick, after that
+// whoever has not picked gets the first pokemons of their list that the
+// ruleset allows. With random teams there is nothing to pick and the fight
+// starts right away, in a draft every ban and pick has its own timer.
+func startPickTimer(id int64, conn *net.UDPConn) {
+	battle := gameStates[id]
+	if battle == nil {
+		return
+	}
+	if battle.Rules.RandomTeams {
+		startRandomBattle(battle, conn)
+		return
+	}
+	if battle.Rules.Draft {
+		startDraft(battle, conn)
+		return
+	}
+	for name := range battle.Players {
+		sendMessage(fmt.Sprintf("You have %d seconds to pick your pokemons!", int(pickTimeout.Seconds())), players[name].Addr, conn)
+		sendMessage("Rules "+battle.Rules.String(), players[name].Addr, conn)
+	}
+	scheduleTimer(battle, *pickTimeout, func() {
+		for name := range battle.Players {
+			if _, picked := battle.Picks[name]; !picked {
+				sendMessage(fmt.Sprintf("Only %d seconds left to pick your pokemons!", int(timeoutWarning.Seconds())), playerAddr(name), conn)
+			}
+		}
+	}, func() {
+		for name := range battle.Players {
+			if _, picked := battle.Picks[name]; picked || players[name] == nil {
+				continue
+			}
+			ids := battle.Rules.autoPick(name)
+			if len(ids) < battle.Rules.TeamSize {
+				continue
+			}
+			sendMessage("Time is up! Your first pokemons were picked for you.", players[name].Addr, conn)
+			processMessage("@pick "+strings.Join(ids, " "), players[name].Addr, conn)
+		}
+		if battle.Status == BattlePicking {
+			// Someone still has no team (not enough pokemons or left), nobody can play
+			for name := range battle.Players {
+				sendMessage("Not every player picked in time.", playerAddr(name), conn)
+			}
+			abortBattle(battle, conn)
+		}
+	})
+}
+
+// startTurnTimer gives the players the battle waits for turnTimeout to act.
+// When the time runs out a random legal action is played for them, and after
+// maxTimeouts missed turns the player forfeits.
+func startTurnTimer(id int64, conn *net.UDPConn) {
+	battle := gameStates[id]
+	if battle == nil {
+		return
+	}
+	waiting := battle.State.Waiting()
+	if len(waiting) == 1 {
+		if p := players[waiting[0]]; p != nil && p.ai != nil {
+			startAITurn(battle, p.ai, waiting[0], conn)
+			return
+		}
+	}
+	scheduleTimer(battle, *turnTimeout, func() {
+		for _, current := range battle.State.Waiting() {
+			sendMessage(fmt.Sprintf("Only %d seconds left for your turn!", int(timeoutWarning.Seconds())), playerAddr(current), conn)
+		}
+	}, func() {
+		for _, current := range waiting {
+			if battle.Status != BattleActive || !isWaitingFor(battle, current) {
+				continue
+			}
+			if players[current] == nil {
+				forfeitBattle(battle, current, "forfeit", conn)
+				return
+			}
+			battle.Timeouts[current]++
+			if battle.Timeouts[current] >= *maxTimeouts {
+				sendMessage("You ran out of time too many times, you forfeit the battle!", players[current].Addr, conn)
+				forfeitBattle(battle, current, "timeout", conn)
+				return
+			}
+			sendMessage(fmt.Sprintf("Time is up! A random move was played for you (%d/%d timeouts).", battle.Timeouts[current], *maxTimeouts), players[current].Addr, conn)
+			for _, command := range randomActions(battle, current) {
+				processMessage(command, players[current].Addr, conn)
+			}
+		}
+	})
+}
+
+func isWaitingFor(battle *Battle, player string) bool {
+	for _, name := range battle.State.Waiting() {
+		if name == player {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleTimer replaces the battle's running timer. warn runs timeoutWarning
+// before the deadline, expire at the deadline, both under mu. A timer that
+// was replaced or stopped in the meantime does nothing.
+func scheduleTimer(battle *Battle, timeout time.Duration, warn func(), expire func()) {
+	stopTimer(battle)
+	seq := battle.timerSeq
+	current := func(f func()) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if battle.timerSeq == seq {
+				f()
+			}
+		}
+	}
+	if timeout <= *timeoutWarning {
+		battle.timer = time.AfterFunc(timeout, current(expire))
+		return
+	}
+	battle.timer = time.AfterFunc(timeout-*timeoutWarning, current(func() {
+		warn()
+		battle.timer = time.AfterFunc(*timeoutWarning, current(expire))
+	}))
+}
+
+func stopTimer(battle *Battle) {
+	battle.timerSeq++
+	if battle.timer != nil {
+		battle.timer.Stop()
+		battle.timer = nil
+	}
+}
+
+// randomAction picks an action the player is allowed to send right now
+func randomAction(battle *Battle, player string) string {
+	var changes []string
+	for _, p := range battle.State.Remaining(player) {
+		if active := battle.State.ActivePokemon(player); active == nil || active.ID != p.ID {
+			changes = append(changes, "@change "+p.ID)
+		}
+	}
+	if battle.State.ForcedSwitch[player] {
+		return changes[rand.Intn(len(changes))]
+	}
+	actions := append([]string{"@attack"}, changes...)
+	return actions[rand.Intn(len(actions))]
+}
+
+// randomActions picks the actions the player still owes this turn, one per
+// slot in doubles
+func randomActions(battle *Battle, player string) []string {
+	state := battle.State
+	if state.Slots == nil {
+		return []string{randomAction(battle, player)}
+	}
+	targets := []string{"1", "2", "all"}
+	if state.Format != engine.Doubles {
+		targets = []string{"all"}
+		for _, name := range state.Opponents(player) {
+			if len(state.Remaining(name)) > 0 {
+				targets = append(targets, name)
+			}
+		}
+	}
+	bench := state.Bench(player)
+	rand.Shuffle(len(bench), func(i, j int) { bench[i], bench[j] = bench[j], bench[i] })
+	var commands []string
+	if state.ForcedSwitch[player] {
+		for i, slot := range state.FaintedSlots(player) {
+			if i < len(bench) {
+				commands = append(commands, fmt.Sprintf("@change %d %s", slot, bench[i].ID))
+			}
+		}
+		return commands
+	}
+	for _, slot := range state.MissingSlots(player) {
+		if len(bench) > 0 && rand.Intn(3) == 0 {
+			commands = append(commands, fmt.Sprintf("@change %d %s", slot, bench[0].ID))
+			bench = bench[1:]
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("@attack %d %s", slot, targets[rand.Intn(len(targets))]))
+	}
+	return commands
+}
+
+// playerAddr returns the address of an online player, nil if they left
+func playerAddr(name string) *net.UDPAddr {
+	if p, ok := players[name]; ok {
+		return p.Addr
+	}
+	return nil
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A tournament is created by an organizer, players sign up, then the
+// organizer starts it. Every round the server pairs the players still in the
+// tournament and starts their battles as soon as both are in the lobby. A
+// player who does not show up for noShowTimeout loses the match. A match
+// whose battle was aborted maxMatchAborts times is decided the same way,
+// without waiting.
+//
+//   - single: a player is out after one loss
+//   - double: a player is out after two losses, players without a loss are
+//     paired together, players with one loss too, and the last two meet in
+//     the final (played again if the player without a loss loses it)
+//   - swiss: everybody plays a fixed number of rounds against players with
+//     the same score, a win is one point
+type (
+	TournamentFormat string
+
+	Tournament struct {
+		Name      string
+		Organizer string
+		Format    TournamentFormat
+		Rounds    int // number of rounds, for swiss
+		Players   []string
+		Status    string // signup, running or finished
+		Round     int
+		Matches   []*TournamentMatch // matches of the current round
+		Wins      map[string]int
+		Losses    map[string]int
+		Byes      map[string]bool
+		Played    map[string]map[string]bool // who played who, to avoid rematches in swiss
+		Winner    string
+	}
+
+	TournamentMatch struct {
+		Players [2]string // the second one is empty for a bye
+		Winner  string
+		battle  *Battle
+		since   time.Time // when the match is waiting for its players
+		aborts  int       // battles of the match that were aborted
+	}
+)
+
+const (
+	SingleElimination TournamentFormat = "single"
+	DoubleElimination TournamentFormat = "double"
+	Swiss             TournamentFormat = "swiss"
+)
+
+var (
+	noShowTimeout  = flag.Duration("no-show-timeout", 2*time.Minute, "time a tournament player has to be available for their match")
+	maxMatchAborts = flag.Int("max-match-aborts", 3, "aborted battles after which a tournament match goes to the available player, or the better seed")
+)
+
+var tournaments = make(map[string]*Tournament)
+
+// tournamentCommand implements "@tournament <create|join|leave|start|cancel|standings|list> ..."
+func tournamentCommand(args string, senderName string, conn *net.UDPConn) {
+	addr := players[senderName].Addr
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		sendMessage("Usage: @tournament create name single|double|swiss [rounds], join|leave|start|cancel|standings name, list", addr, conn)
+		return
+	}
+	if fields[0] == "list" {
+		sendMessage(listTournaments(), addr, conn)
+		return
+	}
+	if len(fields) < 2 {
+		sendMessage("Invalid command", addr, conn)
+		return
+	}
+	name := fields[1]
+	t := tournaments[name]
+	if fields[0] != "create" && t == nil {
+		sendMessage("Error: Tournament '"+name+"' not found, see @tournament list", addr, conn)
+		return
+	}
+
+	switch fields[0] {
+	case "create":
+		if t != nil {
+			sendMessage("Error: Tournament '"+name+"' already exists!", addr, conn)
+			return
+		}
+		if len(fields) < 3 {
+			sendMessage("Invalid command", addr, conn)
+			return
+		}
+		format := TournamentFormat(fields[2])
+		if format != SingleElimination && format != DoubleElimination && format != Swiss {
+			sendMessage("Error: The format must be single, double or swiss!", addr, conn)
+			return
+		}
+		rounds := 0
+		if len(fields) > 3 {
+			n, err := strconv.Atoi(fields[3])
+			if err != nil || n < 1 {
+				sendMessage("Error: Invalid number of rounds!", addr, conn)
+				return
+			}
+			rounds = n
+		}
+		tournaments[name] = &Tournament{
+			Name:      name,
+			Organizer: senderName,
+			Format:    format,
+			Rounds:    rounds,
+			Status:    "signup",
+			Wins:      make(map[string]int),
+			Losses:    make(map[string]int),
+			Byes:      make(map[string]bool),
+			Played:    make(map[string]map[string]bool),
+		}
+		for username := range players {
+			sendMessage(fmt.Sprintf("%s created the %s tournament '%s', sign up with @tournament join %s", senderName, format, name, name), players[username].Addr, conn)
+		}
+	case "join":
+		if t.Status != "signup" {
+			sendMessage("Error: The sign up of '"+name+"' is closed!", addr, conn)
+		} else if t.hasPlayer(senderName) {
+			sendMessage("You already signed up for '"+name+"'!", addr, conn)
+		} else if len(findPlayerPokemonByPlayer(senderName)) < 3 {
+			sendMessage("Error: You need at least 3 pokemons to battle!", addr, conn)
+		} else {
+			t.Players = append(t.Players, senderName)
+			t.broadcast(fmt.Sprintf("%s signed up (%d players)", senderName, len(t.Players)), conn)
+			if !t.hasPlayer(t.Organizer) {
+				sendMessage(fmt.Sprintf("[%s] %s signed up (%d players)", name, senderName, len(t.Players)), playerAddr(t.Organizer), conn)
+			}
+		}
+	case "leave":
+		if t.Status != "signup" || !t.hasPlayer(senderName) {
+			sendMessage("Error: You cannot leave '"+name+"' now!", addr, conn)
+			return
+		}
+		for i, player := range t.Players {
+			if player == senderName {
+				t.Players = append(t.Players[:i], t.Players[i+1:]...)
+				break
+			}
+		}
+		sendMessage("You left the tournament '"+name+"'.", addr, conn)
+	case "start":
+		if senderName != t.Organizer || t.Status != "signup" {
+			sendMessage("Error: Only the organizer can start '"+name+"', once!", addr, conn)
+		} else if len(t.Players) < 2 {
+			sendMessage("Error: A tournament needs at least 2 players!", addr, conn)
+		} else {
+			t.start(conn)
+		}
+	case "cancel":
+		if senderName != t.Organizer || t.Status == "finished" {
+			sendMessage("Error: Only the organizer can cancel '"+name+"'!", addr, conn)
+			return
+		}
+		for _, match := range t.Matches {
+			if match.battle != nil {
+				match.battle.tournament = nil
+			}
+		}
+		t.broadcast("The tournament was cancelled by "+senderName+".", conn)
+		delete(tournaments, name)
+	case "standings":
+		sendMessage(t.standings(), addr, conn)
+	default:
+		sendMessage("Invalid command", addr, conn)
+	}
+}
+
+func listTournaments() string {
+	var lines []string
+	for _, t := range tournaments {
+		line := fmt.Sprintf("%s: %s, %s, %d players, by %s", t.Name, t.Format, t.Status, len(t.Players), t.Organizer)
+		if t.Status == "running" {
+			line += fmt.Sprintf(", round %d", t.Round)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No tournament right now, create one with @tournament create name single|double|swiss"
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func (t *Tournament) hasPlayer(name string) bool {
+	for _, player := range t.Players {
+		if player == name {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcast tells every player of the tournament who is online
+func (t *Tournament) broadcast(message string, conn *net.UDPConn) {
+	for _, player := range t.Players {
+		sendMessage("["+t.Name+"] "+message, playerAddr(player), conn)
+	}
+}
+
+// start seeds the players by rating and pairs the first round
+func (t *Tournament) start(conn *net.UDPConn) {
+	t.Status = "running"
+	sort.SliceStable(t.Players, func(i, j int) bool { return rating(t.Players[i]) > rating(t.Players[j]) })
+	if t.Format == Swiss && t.Rounds == 0 {
+		t.Rounds = int(math.Ceil(math.Log2(float64(len(t.Players)))))
+	}
+	t.broadcast(fmt.Sprintf("The tournament starts with %d players!", len(t.Players)), conn)
+	t.nextRound(conn)
+}
+
+// alive lists the players still in the tournament, by seed
+func (t *Tournament) alive() []string {
+	maxLosses := 1
+	if t.Format == DoubleElimination {
+		maxLosses = 2
+	}
+	var alive []string
+	for _, player := range t.Players {
+		if t.Format == Swiss || t.Losses[player] < maxLosses {
+			alive = append(alive, player)
+		}
+	}
+	return alive
+}
+
+// pairings gives the matches of the next round
+func (t *Tournament) pairings() [][2]string {
+	alive := t.alive()
+	switch t.Format {
+	case SingleElimination:
+		return pairInOrder(alive)
+	case DoubleElimination:
+		var winners, losers []string
+		for _, player := range alive {
+			if t.Losses[player] == 0 {
+				winners = append(winners, player)
+			} else {
+				losers = append(losers, player)
+			}
+		}
+		if len(winners) == 1 && len(losers) == 1 {
+			return [][2]string{{winners[0], losers[0]}} // final
+		}
+		return append(pairInOrder(winners), pairInOrder(losers)...)
+	default:
+		return t.swissPairings()
+	}
+}
+
+// pairInOrder pairs the best seed with the worst one, the best seed gets a
+// bye when the number of players is odd
+func pairInOrder(players []string) [][2]string {
+	var pairs [][2]string
+	if len(players)%2 == 1 {
+		pairs = append(pairs, [2]string{players[0], ""})
+		players = players[1:]
+	}
+	for i := 0; i < len(players)/2; i++ {
+		pairs = append(pairs, [2]string{players[i], players[len(players)-1-i]})
+	}
+	return pairs
+}
+
+// swissPairings pairs players with the same score, avoiding rematches when
+// possible. The lowest ranked player who never had a bye gets one.
+func (t *Tournament) swissPairings() [][2]string {
+	ranked := append([]string(nil), t.Players...)
+	sort.SliceStable(ranked, func(i, j int) bool { return t.Wins[ranked[i]] > t.Wins[ranked[j]] })
+
+	var pairs [][2]string
+	if len(ranked)%2 == 1 {
+		bye := len(ranked) - 1
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if !t.Byes[ranked[i]] {
+				bye = i
+				break
+			}
+		}
+		pairs = append(pairs, [2]string{ranked[bye], ""})
+		ranked = append(ranked[:bye], ranked[bye+1:]...)
+	}
+	for len(ranked) > 0 {
+		opponent := 1
+		for i := 1; i < len(ranked); i++ {
+			if !t.Played[ranked[0]][ranked[i]] {
+				opponent = i
+				break
+			}
+		}
+		pairs = append(pairs, [2]string{ranked[0], ranked[opponent]})
+		ranked = append(ranked[1:opponent], ranked[opponent+1:]...)
+	}
+	return pairs
+}
+
+// nextRound pairs the next round, or ends the tournament when it has a winner
+func (t *Tournament) nextRound(conn *net.UDPConn) {
+	alive := t.alive()
+	if t.Format != Swiss && len(alive) == 1 {
+		t.finish(alive[0], conn)
+		return
+	}
+	if t.Format == Swiss && t.Round == t.Rounds {
+		t.finish(t.rankings()[0], conn)
+		return
+	}
+
+	t.Round++
+	t.Matches = nil
+	var lines []string
+	for _, pair := range t.pairings() {
+		match := &TournamentMatch{Players: pair, since: time.Now()}
+		if pair[1] == "" {
+			match.Winner = pair[0]
+			t.Wins[pair[0]]++
+			t.Byes[pair[0]] = true
+			lines = append(lines, pair[0]+" has a bye")
+		} else {
+			lines = append(lines, pair[0]+" vs "+pair[1])
+		}
+		t.Matches = append(t.Matches, match)
+	}
+	t.broadcast(fmt.Sprintf("Round %d:\n%s", t.Round, strings.Join(lines, "\n")), conn)
+	t.startMatches(conn)
+}
+
+// startMatches starts the battles of the round whose players are both in the
+// lobby. Once a player did not show up for noShowTimeout, or the battle of
+// the match was aborted maxMatchAborts times, the match goes to the player
+// who is in the lobby, or to the better seed when neither or both are.
+func (t *Tournament) startMatches(conn *net.UDPConn) {
+	for _, match := range t.Matches {
+		if match.Winner != "" || match.battle != nil {
+			continue
+		}
+		a, b := match.Players[0], match.Players[1]
+		aReady := players[a] != nil && !isInBattle(a)
+		bReady := players[b] != nil && !isInBattle(b)
+		aborted := match.aborts >= *maxMatchAborts
+		if aReady && bReady && !aborted {
+			t.startMatch(match, conn)
+			continue
+		}
+		if !aborted && time.Since(match.since) < *noShowTimeout {
+			continue
+		}
+		winner, loser := a, b
+		if !aReady && bReady {
+			winner, loser = b, a
+		}
+		if aborted {
+			t.broadcast(fmt.Sprintf("The battle of %s and %s was aborted %d times, %s wins the match", a, b, match.aborts, winner), conn)
+		} else {
+			t.broadcast(fmt.Sprintf("%s did not show up, %s wins the match", loser, winner), conn)
+		}
+		t.recordMatch(match, winner, loser, conn)
+	}
+}
+
+func (t *Tournament) startMatch(match *TournamentMatch, conn *net.UDPConn) {
+	a, b := match.Players[0], match.Players[1]
+	battle := newBattle(a, b)
+	battle.tournament = t
+	if err := acceptBattle(battle, conn); err != nil {
+		fmt.Println("Error starting tournament battle:", err)
+		abortBattle(battle, conn)
+		match.aborts++
+		return
+	}
+	match.battle = battle
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		addr := players[pair[0]].Addr
+		sendMessage(fmt.Sprintf("[%s] Round %d: you battle '%s'!", t.Name, t.Round, pair[1]), addr, conn)
+		sendMessage("@accepted_battle", addr, conn)
+	}
+	startPickTimer(battle.battleID, conn)
+}
+
+// recordMatch stores the result of a match, the next round is paired once all
+// matches of the round have a winner
+func (t *Tournament) recordMatch(match *TournamentMatch, winner string, loser string, conn *net.UDPConn) {
+	match.Winner = winner
+	match.battle = nil
+	t.Wins[winner]++
+	t.Losses[loser]++
+	if t.Played[winner] == nil {
+		t.Played[winner] = make(map[string]bool)
+	}
+	if t.Played[loser] == nil {
+		t.Played[loser] = make(map[string]bool)
+	}
+	t.Played[winner][loser] = true
+	t.Played[loser][winner] = true
+
+	for _, m := range t.Matches {
+		if m.Winner == "" {
+			return
+		}
+	}
+	t.broadcast(t.standings(), conn)
+	t.nextRound(conn)
+}
+
+// tournamentBattleOver is called when a battle of a tournament ends. An
+// aborted battle is played again, up to maxMatchAborts times, or lost by
+// whoever does not show up.
+func tournamentBattleOver(battle *Battle, winner string, loser string, conn *net.UDPConn) {
+	t := battle.tournament
+	if t == nil {
+		return
+	}
+	for _, match := range t.Matches {
+		if match.battle != battle {
+			continue
+		}
+		if winner == "" {
+			match.battle = nil
+			match.since = time.Now()
+			match.aborts++
+			return
+		}
+		t.broadcast(fmt.Sprintf("%s beat %s", winner, loser), conn)
+		t.recordMatch(match, winner, loser, conn)
+		return
+	}
+}
+
+func (t *Tournament) finish(winner string, conn *net.UDPConn) {
+	t.Status = "finished"
+	t.Winner = winner
+	for username := range players {
+		sendMessage(fmt.Sprintf("%s won the tournament '%s'!", winner, t.Name), players[username].Addr, conn)
+	}
+	delete(tournaments, t.Name)
+}
+
+// rankings sorts the players by wins, then fewer losses, then seed
+func (t *Tournament) rankings() []string {
+	ranked := append([]string(nil), t.Players...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if t.Wins[ranked[i]] != t.Wins[ranked[j]] {
+			return t.Wins[ranked[i]] > t.Wins[ranked[j]]
+		}
+		return t.Losses[ranked[i]] < t.Losses[ranked[j]]
+	})
+	return ranked
+}
+
+func (t *Tournament) standings() string {
+	title := fmt.Sprintf("Standings of '%s' (%s", t.Name, t.Format)
+	switch t.Status {
+	case "signup":
+		return fmt.Sprintf("%s, sign up): %s", title, strings.Join(t.Players, ", "))
+	case "running":
+		title += fmt.Sprintf(", round %d", t.Round)
+		if t.Format == Swiss {
+			title += fmt.Sprintf("/%d", t.Rounds)
+		}
+	}
+	lines := []string{title + ")"}
+
+	alive := make(map[string]bool)
+	for _, player := range t.alive() {
+		alive[player] = true
+	}
+	for i, player := range t.rankings() {
+		line := fmt.Sprintf("%d. %s %d-%d", i+1, player, t.Wins[player], t.Losses[player])
+		if t.Format != Swiss && !alive[player] {
+			line += " (out)"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runTournaments starts the waiting tournament matches every interval
+func runTournaments(interval time.Duration, conn *net.UDPConn) {
+	for range time.Tick(interval) {
+		mu.Lock()
+		for _, t := range tournaments {
+			if t.Status == "running" {
+				t.startMatches(conn)
+			}
+		}
+		mu.Unlock()
+	}
+}
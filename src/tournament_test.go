@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestTournament(format TournamentFormat, names ...string) *Tournament {
+	return &Tournament{
+		Name:    "test",
+		Format:  format,
+		Players: names,
+		Status:  "running",
+		Wins:    make(map[string]int),
+		Losses:  make(map[string]int),
+		Byes:    make(map[string]bool),
+		Played:  make(map[string]map[string]bool),
+	}
+}
+
+func TestSwissPairings(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []string
+		wins    map[string]int
+		byes    []string
+		played  [][2]string
+		want    [][2]string
+	}{
+		{
+			name:    "first round in seed order",
+			players: []string{"a", "b", "c", "d"},
+			want:    [][2]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:    "same score together",
+			players: []string{"a", "b", "c", "d"},
+			wins:    map[string]int{"a": 1, "c": 1},
+			played:  [][2]string{{"a", "b"}, {"c", "d"}},
+			want:    [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:    "no rematch when possible",
+			players: []string{"a", "b", "c", "d"},
+			played:  [][2]string{{"a", "b"}},
+			want:    [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:    "rematch when everybody was played",
+			players: []string{"a", "b"},
+			played:  [][2]string{{"a", "b"}},
+			want:    [][2]string{{"a", "b"}},
+		},
+		{
+			name:    "lowest ranked gets the bye",
+			players: []string{"a", "b", "c"},
+			wins:    map[string]int{"a": 1},
+			want:    [][2]string{{"c", ""}, {"a", "b"}},
+		},
+		{
+			name:    "no second bye",
+			players: []string{"a", "b", "c"},
+			wins:    map[string]int{"a": 1, "c": 1},
+			byes:    []string{"c"},
+			played:  [][2]string{{"a", "b"}},
+			want:    [][2]string{{"b", ""}, {"a", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tournament := newTestTournament(Swiss, tt.players...)
+			for name, wins := range tt.wins {
+				tournament.Wins[name] = wins
+			}
+			for _, name := range tt.byes {
+				tournament.Byes[name] = true
+			}
+			for _, pair := range tt.played {
+				for _, p := range [][2]string{pair, {pair[1], pair[0]}} {
+					if tournament.Played[p[0]] == nil {
+						tournament.Played[p[0]] = make(map[string]bool)
+					}
+					tournament.Played[p[0]][p[1]] = true
+				}
+			}
+			if got := tournament.swissPairings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbortedMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		aborts int
+		since  time.Duration // how long the match has been waiting
+		winner string
+	}{
+		{"played again", *maxMatchAborts - 1, 0, ""},
+		{"aborted too often", *maxMatchAborts, 0, "a"},
+		{"no show", 1, *noShowTimeout, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tournament := newTestTournament(SingleElimination, "a", "b")
+			tournaments[tournament.Name] = tournament
+			defer delete(tournaments, tournament.Name)
+			match := &TournamentMatch{Players: [2]string{"a", "b"}, since: time.Now().Add(-tt.since), aborts: tt.aborts}
+			tournament.Matches = []*TournamentMatch{match}
+
+			tournament.startMatches(nil) // a and b are offline, no battle can start
+			if match.Winner != tt.winner {
+				t.Fatalf("winner %q, want %q", match.Winner, tt.winner)
+			}
+			if tt.winner != "" && (tournament.Status != "finished" || tournament.Winner != tt.winner) {
+				t.Errorf("the tournament is %s, won by %q", tournament.Status, tournament.Winner)
+			}
+		})
+	}
+}
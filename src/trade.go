@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Two players trade pokemons, items of their bag and coins in a trade
+// session:
+//
+//   - "@trade player" asks a player for a trade, the same command from the
+//     other player opens the session. A player has one session at a time.
+//   - "@trade offer pokemonID", "@trade offer item name [count]" and
+//     "@trade offer coins n" add to the offer of the player, "@trade remove"
+//     with the same arguments takes it back
+//   - "@trade show" shows both offers with the full stats of the pokemons
+//   - the trade needs two confirmations: "@trade ready" locks the offer of a
+//     player, and once both are locked "@trade confirm" from both players
+//     makes the trade. Any change to an offer unlocks both of them.
+//   - "@trade cancel" ends the session, or the requests of the player
+//
+// The trade checks both offers again when it is made and moves everything or
+// nothing, then saves the player store and appends the trade to the trade
+// history, which "@trade history" shows.
+type (
+	Trade struct {
+		Players   [2]string
+		Offers    map[string]*TradeOffer
+		Ready     map[string]bool
+		Confirmed map[string]bool
+	}
+
+	TradeOffer struct {
+		Pokemons []string       `json:"Pokemons,omitempty"` // pokemon IDs
+		Items    map[string]int `json:"Items,omitempty"`
+		Coins    int            `json:"Coins,omitempty"`
+	}
+
+	TradeRecord struct { // a trade in the trade history
+		Players [2]string               `json:"Players"`
+		Gave    map[string]TradeHistory `json:"Gave"` // what every player gave
+		At      time.Time               `json:"At"`
+	}
+
+	TradeHistory struct {
+		Pokemons []TradedPokemon `json:"Pokemons,omitempty"`
+		Items    map[string]int  `json:"Items,omitempty"`
+		Coins    int             `json:"Coins,omitempty"`
+	}
+
+	TradedPokemon struct {
+		ID    string `json:"ID"`
+		Name  string `json:"Name"`
+		NewID string `json:"NewID"` // ID with the new owner
+	}
+)
+
+var (
+	tradeHistoryData = filepath.Join("src", "trades.json")
+	trades           = make(map[string]*Trade) // open sessions, by both of their players
+	tradeRequests    = make(map[string]string) // requester to the player they asked
+)
+
+// tradeCommand implements "@trade <player|offer|remove|show|ready|confirm|cancel|history> ..."
+func tradeCommand(args string, senderName string, conn *net.UDPConn) {
+	addr := players[senderName].Addr
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		sendMessage("Usage: @trade player, offer|remove pokemonID|item name [count]|coins n, show, ready, confirm, cancel, history", addr, conn)
+		return
+	}
+	t := trades[senderName]
+	switch fields[0] {
+	case "history":
+		sendMessage(tradeHistory(senderName), addr, conn)
+		return
+	case "cancel":
+		requested := tradeRequests[senderName] != ""
+		for _, other := range tradeRequests {
+			requested = requested || other == senderName
+		}
+		if t == nil && !requested {
+			sendMessage("Error: You have no trade to cancel!", addr, conn)
+			return
+		}
+		cancelTrade(senderName, conn)
+		sendMessage("You cancelled the trade.", addr, conn)
+		return
+	case "offer", "remove", "show", "ready", "confirm":
+		if t == nil {
+			sendMessage("Error: You are not trading, start with @trade player", addr, conn)
+			return
+		}
+	default:
+		requestTrade(senderName, fields[0], conn)
+		return
+	}
+
+	other := t.other(senderName)
+	switch fields[0] {
+	case "offer", "remove":
+		if len(fields) < 2 {
+			sendMessage("Usage: @trade "+fields[0]+" pokemonID|item name [count]|coins n", addr, conn)
+			return
+		}
+		change, err := t.change(senderName, fields[0] == "offer", fields[1:])
+		if err != nil {
+			sendMessage("Error: "+err.Error(), addr, conn)
+			return
+		}
+		t.Ready, t.Confirmed = make(map[string]bool), make(map[string]bool)
+		sendMessage("You "+change+", see @trade show", addr, conn)
+		sendMessage(senderName+" "+strings.Replace(change, "your", "their", 1)+", see @trade show", playerAddr(other), conn)
+	case "show":
+		sendMessage(t.describe(), addr, conn)
+	case "ready":
+		if len(t.Offers[senderName].Pokemons) == 0 && len(t.Offers[senderName].Items) == 0 && t.Offers[senderName].Coins == 0 &&
+			len(t.Offers[other].Pokemons) == 0 && len(t.Offers[other].Items) == 0 && t.Offers[other].Coins == 0 {
+			sendMessage("Error: Nobody offers anything yet!", addr, conn)
+			return
+		}
+		t.Ready[senderName] = true
+		if !t.Ready[other] {
+			sendMessage("Your offer is locked, waiting for "+other+".", addr, conn)
+			sendMessage(senderName+" locked their offer, check it with @trade show and lock yours with @trade ready.", playerAddr(other), conn)
+			return
+		}
+		for _, name := range t.Players {
+			sendMessage(t.describe()+"\nBoth offers are locked, make the trade with @trade confirm.", playerAddr(name), conn)
+		}
+	case "confirm":
+		if !t.Ready[senderName] || !t.Ready[other] {
+			sendMessage("Error: Both players must lock their offer with @trade ready first!", addr, conn)
+			return
+		}
+		t.Confirmed[senderName] = true
+		if !t.Confirmed[other] {
+			sendMessage("You confirmed the trade, waiting for "+other+".", addr, conn)
+			sendMessage(senderName+" confirmed the trade, make it with @trade confirm.", playerAddr(other), conn)
+			return
+		}
+		record, err := t.execute(time.Now())
+		if err != nil {
+			t.Ready, t.Confirmed = make(map[string]bool), make(map[string]bool)
+			for _, name := range t.Players {
+				sendMessage("Error: The trade failed, "+err.Error(), playerAddr(name), conn)
+			}
+			return
+		}
+		delete(trades, t.Players[0])
+		delete(trades, t.Players[1])
+		if err := savePlayerPokemon(); err != nil {
+			fmt.Println("Error saving player data:", err)
+		}
+		if err := archiveTrade(record); err != nil {
+			fmt.Println("Error saving trade history:", err)
+		}
+		for _, name := range t.Players {
+			sendMessage("Trade done! "+describeReceived(record, name), playerAddr(name), conn)
+		}
+	}
+}
+
+// requestTrade asks a player for a trade, or opens the session when they
+// asked first
+func requestTrade(senderName string, other string, conn *net.UDPConn) {
+	addr := players[senderName].Addr
+	switch {
+	case other == senderName || isAI(other):
+		sendMessage("Invalid command", addr, conn)
+		return
+	case !checkExistedPlayer(other):
+		sendMessage("Error: Player '"+other+"' did not exist in the server!", addr, conn)
+		return
+	case trades[senderName] != nil:
+		sendMessage("Error: You are already trading, see @trade show or @trade cancel", addr, conn)
+		return
+	case trades[other] != nil:
+		sendMessage("Error: Player '"+other+"' is already trading!", addr, conn)
+		return
+	}
+	if tradeRequests[other] != senderName {
+		if previous := tradeRequests[senderName]; previous != "" && previous != other {
+			sendMessage(senderName+" cancelled their trade request.", playerAddr(previous), conn)
+		}
+		tradeRequests[senderName] = other
+		sendMessage("You asked '"+other+"' for a trade.", addr, conn)
+		sendMessage("Player '"+senderName+"' wants to trade with you, answer with @trade "+senderName, playerAddr(other), conn)
+		return
+	}
+	delete(tradeRequests, other)
+	if previous := tradeRequests[senderName]; previous != "" {
+		delete(tradeRequests, senderName)
+		sendMessage(senderName+" cancelled their trade request.", playerAddr(previous), conn)
+	}
+	t := &Trade{
+		Players:   [2]string{other, senderName},
+		Offers:    map[string]*TradeOffer{other: {}, senderName: {}},
+		Ready:     make(map[string]bool),
+		Confirmed: make(map[string]bool),
+	}
+	trades[other], trades[senderName] = t, t
+	for _, name := range t.Players {
+		sendMessage("Trade between "+other+" and "+senderName+" opened, add to your offer with @trade offer pokemonID|item name [count]|coins n", playerAddr(name), conn)
+	}
+}
+
+// cancelTrade ends the trade session and the trade request of a player, when
+// they cancel it or quit. The requests other players sent them go too.
+func cancelTrade(name string, conn *net.UDPConn) {
+	if other := tradeRequests[name]; other != "" {
+		delete(tradeRequests, name)
+		sendMessage(name+" cancelled their trade request.", playerAddr(other), conn)
+	}
+	for requester, other := range tradeRequests {
+		if other == name {
+			delete(tradeRequests, requester)
+			sendMessage(name+" is not available for a trade anymore.", playerAddr(requester), conn)
+		}
+	}
+	t := trades[name]
+	if t == nil {
+		return
+	}
+	delete(trades, t.Players[0])
+	delete(trades, t.Players[1])
+	sendMessage(name+" cancelled the trade.", playerAddr(t.other(name)), conn)
+}
+
+func (t *Trade) other(name string) string {
+	if t.Players[0] == name {
+		return t.Players[1]
+	}
+	return t.Players[0]
+}
+
+// change adds to the offer of a player, or takes back from it, and describes
+// the change
+func (t *Trade) change(player string, add bool, args []string) (string, error) {
+	offer := t.Offers[player]
+	record := findPlayerRecord(player)
+	verb := "took back"
+	if add {
+		verb = "offered"
+	}
+	switch args[0] {
+	case "coins":
+		coins := 0
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("invalid number of coins")
+			}
+			coins = n
+		}
+		if !add {
+			offer.Coins = 0
+			return "took back your coins", nil
+		}
+		if coins > record.Coins {
+			return "", fmt.Errorf("you have %d coins", record.Coins)
+		}
+		offer.Coins = coins
+		return fmt.Sprintf("offered %d coins", coins), nil
+	case "item":
+		args = args[1:]
+		count := 1
+		if len(args) > 1 {
+			if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+				count, args = n, args[:len(args)-1]
+			}
+		}
+		key, item := findBagItem(strings.Join(args, " "))
+		switch {
+		case item == nil:
+			return "", fmt.Errorf("there is no item %s", strings.Join(args, " "))
+		case count < 1:
+			return "", fmt.Errorf("invalid number of items")
+		}
+		if !add {
+			if offer.Items[key] == 0 {
+				return "", fmt.Errorf("your offer has no %s", item.Name)
+			}
+			if offer.Items[key] -= count; offer.Items[key] <= 0 {
+				delete(offer.Items, key)
+			}
+			return fmt.Sprintf("took back %s, %d left in your offer", item.Name, offer.Items[key]), nil
+		}
+		if record.Bag[key] < offer.Items[key]+count {
+			return "", fmt.Errorf("you have %d %s in your bag", record.Bag[key], item.Name)
+		}
+		if offer.Items == nil {
+			offer.Items = make(map[string]int)
+		}
+		offer.Items[key] += count
+		return fmt.Sprintf("offered %d %s", count, item.Name), nil
+	}
+	id := args[0]
+	p := ownedPokemon(player, id)
+	if p == nil {
+		return "", fmt.Errorf("you have no pokemon %s", id)
+	}
+	for i, offered := range offer.Pokemons {
+		if offered != id {
+			continue
+		}
+		if add {
+			return "", fmt.Errorf("%s is already in your offer", id)
+		}
+		offer.Pokemons = append(offer.Pokemons[:i], offer.Pokemons[i+1:]...)
+		return fmt.Sprintf("took back %s %s", id, displayName(*p)), nil
+	}
+	if !add {
+		return "", fmt.Errorf("%s is not in your offer", id)
+	}
+	offer.Pokemons = append(offer.Pokemons, id)
+	return fmt.Sprintf("%s %s %s", verb, id, displayName(*p)), nil
+}
+
+// describe shows both offers with the full stats of their pokemons
+func (t *Trade) describe() string {
+	var lines []string
+	for _, name := range t.Players {
+		offer := t.Offers[name]
+		status := ""
+		switch {
+		case t.Confirmed[name]:
+			status = " (confirmed)"
+		case t.Ready[name]:
+			status = " (locked)"
+		}
+		lines = append(lines, name+" offers"+status+":")
+		for _, id := range offer.Pokemons {
+			if p := ownedPokemon(name, id); p != nil {
+				lines = append(lines, "  "+describePokemonStats(*p))
+			}
+		}
+		var items []string
+		for key, count := range offer.Items {
+			items = append(items, fmt.Sprintf("  %s x%d", shopItems[key].Name, count))
+		}
+		sort.Strings(items)
+		lines = append(lines, items...)
+		if offer.Coins > 0 {
+			lines = append(lines, fmt.Sprintf("  %d coins", offer.Coins))
+		}
+		if len(offer.Pokemons) == 0 && len(offer.Items) == 0 && offer.Coins == 0 {
+			lines = append(lines, "  nothing")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describePokemonStats shows a pokemon of a player with all its stats
+func describePokemonStats(p PlayerPokeInfo) string {
+	return fmt.Sprintf("Pokemon ID: %s, Name: %s, Level: %d, Exp: %d, Types: [%s], HP: %s, ATK: %d, DEF: %d, Sp.Atk: %d, Sp.Def: %d, Speed: %d%s",
+		p.ID, displayName(p), p.Level, p.Exp, strings.Join(speciesTypes(&p), ", "), describeHp(p), p.Atk, p.Def, p.SpAtk, p.SpDef, p.Speed, describeHeld(p))
+}
+
+// execute makes the trade once both players confirmed it. It checks both
+// offers first so that nothing moves when one of them is not valid anymore.
+func (t *Trade) execute(now time.Time) (TradeRecord, error) {
+	for _, name := range t.Players {
+		if isInBattle(name) {
+			return TradeRecord{}, fmt.Errorf("%s is in a battle", name)
+		}
+		findPlayerRecord(name) // adds the record first, which can move the others
+	}
+	records := make(map[string]*PlayerPokemon)
+	for _, name := range t.Players {
+		records[name] = findPlayerRecord(name)
+		offer := t.Offers[name]
+		for _, id := range offer.Pokemons {
+			if ownedPokemon(name, id) == nil {
+				return TradeRecord{}, fmt.Errorf("%s does not have %s anymore", name, id)
+			}
+		}
+		for key, count := range offer.Items {
+			if records[name].Bag[key] < count {
+				return TradeRecord{}, fmt.Errorf("%s does not have %d %s anymore", name, count, shopItems[key].Name)
+			}
+		}
+		if records[name].Coins < offer.Coins {
+			return TradeRecord{}, fmt.Errorf("%s does not have %d coins anymore", name, offer.Coins)
+		}
+	}
+
+	record := TradeRecord{Players: t.Players, Gave: make(map[string]TradeHistory), At: now}
+	given := make(map[string][]PlayerPokeInfo)
+	for _, name := range t.Players {
+		from := records[name]
+		offered := make(map[string]bool)
+		for _, id := range t.Offers[name].Pokemons {
+			offered[id] = true
+		}
+		var kept []PlayerPokeInfo
+		for _, p := range from.PlayerPokeInfo {
+			if offered[p.ID] {
+				given[name] = append(given[name], p)
+			} else {
+				kept = append(kept, p)
+			}
+		}
+		from.PlayerPokeInfo = kept
+	}
+	for _, name := range t.Players {
+		from, to, offer := records[name], records[t.other(name)], t.Offers[name]
+		history := TradeHistory{Items: offer.Items, Coins: offer.Coins}
+		for _, p := range given[name] {
+			traded := TradedPokemon{ID: p.ID, Name: p.Name}
+			p.ID = freePokemonID(to)
+			traded.NewID = p.ID
+			to.PlayerPokeInfo = append(to.PlayerPokeInfo, p)
+			history.Pokemons = append(history.Pokemons, traded)
+		}
+		for key, count := range offer.Items {
+			if from.Bag[key] -= count; from.Bag[key] == 0 {
+				delete(from.Bag, key)
+			}
+			addToBag(to, key, count)
+		}
+		from.Coins -= offer.Coins
+		to.Coins += offer.Coins
+		record.Gave[name] = history
+	}
+	return record, nil
+}
+
+// freePokemonID is the first "#NNN" ID a player does not use, for a pokemon
+// they get in a trade
+func freePokemonID(record *PlayerPokemon) string {
+	used := make(map[string]bool)
+	for _, p := range record.PlayerPokeInfo {
+		used[p.ID] = true
+	}
+	for n := len(record.PlayerPokeInfo) + 1; ; n++ {
+		if id := fmt.Sprintf("#%03d", n); !used[id] {
+			return id
+		}
+	}
+}
+
+// describeReceived tells a player what they got in a trade
+func describeReceived(record TradeRecord, player string) string {
+	other := record.Players[0]
+	if other == player {
+		other = record.Players[1]
+	}
+	var parts []string
+	got := record.Gave[other]
+	for _, p := range got.Pokemons {
+		parts = append(parts, fmt.Sprintf("%s (now %s)", p.Name, p.NewID))
+	}
+	var items []string
+	for key, count := range got.Items {
+		items = append(items, fmt.Sprintf("%d %s", count, shopItems[key].Name))
+	}
+	sort.Strings(items)
+	parts = append(parts, items...)
+	if got.Coins > 0 {
+		parts = append(parts, fmt.Sprintf("%d coins", got.Coins))
+	}
+	if len(parts) == 0 {
+		return "You got nothing from " + other + "."
+	}
+	return "You got from " + other + ": " + strings.Join(parts, ", ") + "."
+}
+
+// archiveTrade appends a trade to the trade history
+func archiveTrade(record TradeRecord) error {
+	records, err := loadTradeHistory()
+	if err != nil {
+		return err
+	}
+	records = append(records, record)
+	data, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tradeHistoryData, data, 0644)
+}
+
+func loadTradeHistory() ([]TradeRecord, error) {
+	var records []TradeRecord
+	data, err := ioutil.ReadFile(tradeHistoryData)
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return records, nil
+}
+
+// tradeHistory lists the last trades of a player
+func tradeHistory(player string) string {
+	records, err := loadTradeHistory()
+	if err != nil {
+		fmt.Println("Error reading trade history:", err)
+		return "Error: The trade history cannot be read!"
+	}
+	var lines []string
+	for i := len(records) - 1; i >= 0 && len(lines) < 10; i-- {
+		r := records[i]
+		if r.Players[0] != player && r.Players[1] != player {
+			continue
+		}
+		lines = append(lines, r.At.Format("2006-01-02 15:04")+" "+describeReceived(r, player))
+	}
+	if len(lines) == 0 {
+		return "You have not traded yet."
+	}
+	return "Your last trades:\n" + strings.Join(lines, "\n")
+}
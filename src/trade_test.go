@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRecords replaces the player store with records for a test
+func useRecords(t *testing.T, records []PlayerPokemon) {
+	t.Helper()
+	saved := playersPokemons
+	t.Cleanup(func() { playersPokemons = saved })
+	playersPokemons = records
+}
+
+func tradeRecords() []PlayerPokemon {
+	return []PlayerPokemon{
+		{Owner: "ash", Coins: 100, Bag: map[string]int{"potion": 3}, PlayerPokeInfo: []PlayerPokeInfo{
+			{ID: "#001", Name: "Pikachu", Level: 5, Hp: 35, Item: "Oran Berry", Damage: 10},
+			{ID: "#002", Name: "Bulbasaur", Level: 5, Hp: 45},
+		}},
+		{Owner: "gary", Coins: 80, PlayerPokeInfo: []PlayerPokeInfo{
+			{ID: "#001", Name: "Squirtle", Level: 5, Hp: 44},
+			{ID: "#002", Name: "Eevee", Level: 5, Hp: 55},
+		}},
+	}
+}
+
+func newTestTrade(ash TradeOffer, gary TradeOffer) *Trade {
+	return &Trade{
+		Players:   [2]string{"ash", "gary"},
+		Offers:    map[string]*TradeOffer{"ash": &ash, "gary": &gary},
+		Ready:     map[string]bool{"ash": true, "gary": true},
+		Confirmed: map[string]bool{"ash": true, "gary": true},
+	}
+}
+
+func TestTradeExecute(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	useRecords(t, tradeRecords())
+	trade := newTestTrade(
+		TradeOffer{Pokemons: []string{"#001"}, Items: map[string]int{"potion": 2}},
+		TradeOffer{Pokemons: []string{"#002"}, Coins: 50},
+	)
+	record, err := trade.execute(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ash, gary := findPlayerRecord("ash"), findPlayerRecord("gary")
+	if ash.Coins != 150 || gary.Coins != 30 {
+		t.Errorf("coins: ash %d, gary %d, want 150 and 30", ash.Coins, gary.Coins)
+	}
+	if ash.Bag["potion"] != 1 || gary.Bag["potion"] != 2 {
+		t.Errorf("potions: ash %d, gary %d, want 1 and 2", ash.Bag["potion"], gary.Bag["potion"])
+	}
+	pikachu := ownedPokemon("gary", "#002") // the ID Eevee left
+	if pikachu == nil || pikachu.Name != "Pikachu" {
+		t.Fatalf("Pikachu should be gary's #002, gary has %v", gary.PlayerPokeInfo)
+	}
+	if pikachu.Item != "Oran Berry" || pikachu.Damage != 10 {
+		t.Errorf("Pikachu lost its item or injuries: %+v", *pikachu)
+	}
+	if eevee := ownedPokemon("ash", "#001"); eevee != nil {
+		t.Errorf("ash's #001 should be free after the trade, it is %s", eevee.Name)
+	}
+	if eevee := ownedPokemon("ash", "#003"); eevee == nil || eevee.Name != "Eevee" {
+		t.Errorf("Eevee should be ash's #003, ash has %v", ash.PlayerPokeInfo)
+	}
+
+	want := TradeRecord{Players: [2]string{"ash", "gary"}, At: now, Gave: map[string]TradeHistory{
+		"ash":  {Pokemons: []TradedPokemon{{ID: "#001", Name: "Pikachu", NewID: "#002"}}, Items: map[string]int{"potion": 2}},
+		"gary": {Pokemons: []TradedPokemon{{ID: "#002", Name: "Eevee", NewID: "#003"}}, Coins: 50},
+	}}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("record %+v, want %+v", record, want)
+	}
+}
+
+func TestTradeExecuteChecksOffers(t *testing.T) {
+	tests := []struct {
+		name     string
+		ash      TradeOffer
+		gary     TradeOffer
+		inBattle string
+		err      string
+	}{
+		{"pokemon gone", TradeOffer{Pokemons: []string{"#009"}}, TradeOffer{Coins: 10}, "", "ash does not have #009 anymore"},
+		{"items gone", TradeOffer{Items: map[string]int{"potion": 4}}, TradeOffer{Coins: 10}, "", "ash does not have 4 Potion anymore"},
+		{"coins gone", TradeOffer{Pokemons: []string{"#001"}}, TradeOffer{Coins: 90}, "", "gary does not have 90 coins anymore"},
+		{"in a battle", TradeOffer{Pokemons: []string{"#001"}}, TradeOffer{Coins: 10}, "gary", "gary is in a battle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRecords(t, tradeRecords())
+			if tt.inBattle != "" {
+				players[tt.inBattle] = &Player{battleID: 1}
+				defer delete(players, tt.inBattle)
+			}
+			before, _ := json.Marshal(playersPokemons)
+			_, err := newTestTrade(tt.ash, tt.gary).execute(time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if after, _ := json.Marshal(playersPokemons); string(after) != string(before) {
+				t.Errorf("a failed trade changed the store:\n%s", after)
+			}
+		})
+	}
+}